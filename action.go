@@ -6,14 +6,68 @@ import (
 	"github.com/go-qbit/multistate/expr"
 )
 
-type action struct {
-	id         string
-	caption    string
-	from       expr.Expression
-	set        []uint64
-	reset      []uint64
-	do         ActionDoFunc
-	availabler Availabler
+type action[S StateValue] struct {
+	id             string
+	caption        string
+	from           expr.Expression
+	set            []S
+	reset          []S
+	ops            stateOps[S]
+	do             ActionDoFuncOf[S]
+	doName         string
+	availabler     Availabler
+	automatic      bool
+	compensate     ActionDoFuncOf[S]
+	compensateName string
+}
+
+// apply sets the set bits and then clears the reset ones.
+func (a *action[S]) apply(state S) S {
+	for _, v := range a.set {
+		state = a.ops.or(state, v)
+	}
+	for _, v := range a.reset {
+		state = a.ops.andNot(state, v)
+	}
+
+	return state
+}
+
+func (a *action[S]) setMask() S {
+	var mask S
+	for _, v := range a.set {
+		mask = a.ops.or(mask, v)
+	}
+
+	return mask
+}
+
+// unavailableReasons checks the availabler of the action and returns nil if
+// the action is available. The entity is nil when only the state is known,
+// EntityAvailabler falls back to IsAvailable then.
+func (a *action[S]) unavailableReasons(ctx context.Context, entity EntityOf[S], state S) []Reason {
+	var available bool
+
+	switch avail := a.availabler.(type) {
+	case nil:
+		return nil
+	case ReasonAvailablerOf[S]:
+		return avail.UnavailableReasons(ctx, entity, state)
+	case EntityAvailablerOf[S]:
+		if entity != nil {
+			available = avail.IsAvailableFor(ctx, entity, state)
+		} else {
+			available = avail.IsAvailable(ctx)
+		}
+	default:
+		available = avail.IsAvailable(ctx)
+	}
+
+	if available {
+		return nil
+	}
+
+	return []Reason{{Code: a.availabler.String()}}
 }
 
 type Availabler interface {
@@ -21,12 +75,54 @@ type Availabler interface {
 	IsAvailable(ctx context.Context) bool
 }
 
-type ActionDoFunc func(ctx context.Context, entry Entity, opts ...any) error
+// EntityAvailablerOf is an Availabler able to inspect the entity. DoAction
+// and GetEntityActions call IsAvailableFor instead of IsAvailable.
+type EntityAvailablerOf[S StateValue] interface {
+	Availabler
+	IsAvailableFor(ctx context.Context, entity EntityOf[S], state S) bool
+}
+
+type EntityAvailabler = EntityAvailablerOf[uint64]
+
+// Reason explains why an action is unavailable.
+type Reason struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReasonAvailablerOf is an Availabler explaining why an action is
+// unavailable. It is used instead of IsAvailable and IsAvailableFor and must
+// return no reasons for an available action. The entity is nil when only the
+// state is known, e.g. in GetStateActionsDetailed.
+type ReasonAvailablerOf[S StateValue] interface {
+	Availabler
+	UnavailableReasons(ctx context.Context, entity EntityOf[S], state S) []Reason
+}
+
+type ReasonAvailabler = ReasonAvailablerOf[uint64]
 
-type Entity interface {
+type ActionDoFuncOf[S StateValue] func(ctx context.Context, entry EntityOf[S], opts ...any) error
+
+type ActionDoFunc = ActionDoFuncOf[uint64]
+
+// EntityOf is an object whose state is kept by a multistate, Entity for
+// Multistate and BitsetEntity for BitsetMultistate.
+type EntityOf[S StateValue] interface {
 	StartAction(ctx context.Context) (context.Context, error)
-	GetState(ctx context.Context) (uint64, error)
-	SetState(ctx context.Context, newState uint64, params ...interface{}) error
+	GetState(ctx context.Context) (S, error)
+	SetState(ctx context.Context, newState S, params ...interface{}) error
 	EndAction(ctx context.Context, err error) error
 	GetId() interface{}
 }
+
+type Entity = EntityOf[uint64]
+
+// CASEntityOf is an entity supporting optimistic concurrency. DoAction uses
+// CompareAndSetState instead of SetState, it must set the new state only if
+// the current one is still expected and report whether it was set.
+type CASEntityOf[S StateValue] interface {
+	EntityOf[S]
+	CompareAndSetState(ctx context.Context, expected, newState S) (bool, error)
+}
+
+type CASEntity = CASEntityOf[uint64]
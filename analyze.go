@@ -0,0 +1,167 @@
+package multistate
+
+import (
+	"sort"
+
+	"github.com/go-qbit/multistate/expr"
+)
+
+// Report describes the problems of a compiled multistate found by Analyze.
+type Report struct {
+	// DeadActions never fire from any reachable state.
+	DeadActions []string
+
+	// UnsetFlags are the ids of the flags never set in any reachable state.
+	UnsetFlags []string
+
+	// TerminalStates have no outgoing actions.
+	TerminalStates []uint64
+
+	// Components partitions the reachable states into strongly connected
+	// components, the states of each component and the components sorted.
+	Components [][]uint64
+
+	// TrapStates can't reach any final state.
+	TrapStates []uint64
+}
+
+// Analyze inspects the compiled graph. The states satisfying final are the
+// designated final ones, TrapStates is left empty if final is nil.
+func (m *Multistate) Analyze(final expr.Expression) (Report, error) {
+	if m.IsLazy() {
+		return Report{}, ErrLazyMode
+	}
+
+	var report Report
+	states := m.sortedStates()
+
+	fired := make(map[string]bool)
+	var seenBits uint64
+	for _, state := range states {
+		seenBits |= state
+		if len(m.statesActions[state]) == 0 {
+			report.TerminalStates = append(report.TerminalStates, state)
+		}
+		for action := range m.statesActions[state] {
+			fired[action] = true
+			if len(m.automaticIds) > 0 {
+				steps, _, _ := m.automaticChain(m.actionsMap[action].apply(state))
+				for _, step := range steps {
+					fired[step.action] = true
+				}
+			}
+		}
+	}
+
+	for _, id := range m.sortedActionIds() {
+		if !fired[id] {
+			report.DeadActions = append(report.DeadActions, id)
+		}
+	}
+
+	for _, flag := range m.GetAllStateFlags() {
+		if seenBits&(1<<flag.Bit) == 0 {
+			report.UnsetFlags = append(report.UnsetFlags, flag.Id)
+		}
+	}
+
+	report.Components = m.components(states)
+
+	if final != nil {
+		report.TrapStates = m.trapStates(states, final)
+	}
+
+	return report, nil
+}
+
+// components finds the strongly connected components with Tarjan's algorithm.
+func (m *Multistate) components(states []uint64) [][]uint64 {
+	index := make(map[uint64]int, len(states))
+	lowLink := make(map[uint64]int, len(states))
+	onStack := make(map[uint64]bool)
+	var stack []uint64
+	var res [][]uint64
+
+	var connect func(state uint64)
+	connect = func(state uint64) {
+		index[state] = len(index)
+		lowLink[state] = index[state]
+		stack = append(stack, state)
+		onStack[state] = true
+
+		for _, to := range m.statesActions[state] {
+			if _, visited := index[to]; !visited {
+				connect(to)
+				if lowLink[to] < lowLink[state] {
+					lowLink[state] = lowLink[to]
+				}
+			} else if onStack[to] && index[to] < lowLink[state] {
+				lowLink[state] = index[to]
+			}
+		}
+
+		if lowLink[state] == index[state] {
+			var component []uint64
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == state {
+					break
+				}
+			}
+			sort.Slice(component, func(i, j int) bool { return component[i] < component[j] })
+			res = append(res, component)
+		}
+	}
+
+	for _, state := range states {
+		if _, visited := index[state]; !visited {
+			connect(state)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i][0] < res[j][0] })
+
+	return res
+}
+
+// trapStates walks the graph backwards from the final states.
+func (m *Multistate) trapStates(states []uint64, final expr.Expression) []uint64 {
+	reverse := make(map[uint64][]uint64)
+	for _, from := range states {
+		for _, to := range m.statesActions[from] {
+			reverse[to] = append(reverse[to], from)
+		}
+	}
+
+	reached := make(map[uint64]bool)
+	var queue []uint64
+	for _, state := range states {
+		if final.Eval(state) {
+			reached[state] = true
+			queue = append(queue, state)
+		}
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, from := range reverse[state] {
+			if !reached[from] {
+				reached[from] = true
+				queue = append(queue, from)
+			}
+		}
+	}
+
+	var res []uint64
+	for _, state := range states {
+		if !reached[state] {
+			res = append(res, state)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,124 @@
+package multistate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+const defaultMaxAutomaticSteps = 32
+
+// WithMaxAutomaticSteps limits the chains of automatic actions, 32 steps by
+// default. Longer chains fail with ErrAutomaticLoop.
+func WithMaxAutomaticSteps(n int) CompileOption {
+	return func(o *compileOptions) {
+		o.maxAutomaticSteps = n
+	}
+}
+
+// SetAutomatic marks the action as automatic. It can't be done by DoAction,
+// instead it fires as soon as an action leads to a state it changes, its
+// availabler ignored. Compile follows the chains of automatic actions, so the
+// graph only has the stable states, and DoAction runs their callbacks within
+// the same action. It must be called before Compile.
+func (m *machine[S]) SetAutomatic(action string) error {
+	a, exists := m.actionsMap[action]
+	if !exists {
+		return fmt.Errorf("action '%s' doesn't exists", action)
+	}
+	if m.compiled() {
+		return fmt.Errorf("action '%s' can't be made automatic, multistate is already compiled", action)
+	}
+	if !a.automatic {
+		a.automatic = true
+		m.automaticIds = append(m.automaticIds, action)
+		sort.Strings(m.automaticIds)
+	}
+
+	return nil
+}
+
+func (m *machine[S]) MustSetAutomatic(action string) {
+	if err := m.SetAutomatic(action); err != nil {
+		panic(err)
+	}
+}
+
+func (m *machine[S]) IsAutomatic(action string) bool {
+	a, exists := m.actionsMap[action]
+	return exists && a.automatic
+}
+
+type automaticStep[S StateValue] struct {
+	action   string
+	from, to S
+}
+
+// automaticChain fires the automatic actions from the state, the first one in
+// the order of ids each time, until none changes the state.
+func (m *machine[S]) automaticChain(state S) ([]automaticStep[S], S, error) {
+	var steps []automaticStep[S]
+	seen := map[S]bool{state: true}
+
+	for {
+		fired := false
+		for _, id := range m.automaticIds {
+			a := m.actionsMap[id]
+			if !m.ops.eval(a.from, state) {
+				continue
+			}
+
+			newState := a.apply(state)
+			if newState == state {
+				continue
+			}
+
+			if seen[newState] {
+				return nil, state, fmt.Errorf("action '%s' from %v returns to %v: %w", id, state, newState, ErrAutomaticLoop)
+			}
+			if len(steps) >= m.maxAutomaticSteps {
+				return nil, state, fmt.Errorf("more than %d automatic steps from %v: %w", m.maxAutomaticSteps, steps[0].from, ErrAutomaticLoop)
+			}
+
+			steps = append(steps, automaticStep[S]{id, state, newState})
+			seen[newState] = true
+			state = newState
+			fired = true
+			break
+		}
+
+		if !fired {
+			return steps, state, nil
+		}
+	}
+}
+
+// runAutomatic runs the callbacks of the automatic actions fired from the
+// state.
+func (m *machine[S]) runAutomatic(ctx context.Context, entity EntityOf[S], state S, opts []interface{}, done *compensations[S]) (ActionPhase, error) {
+	if len(m.automaticIds) == 0 {
+		return "", nil
+	}
+
+	steps, _, err := m.automaticChain(state)
+	if err != nil {
+		return PhaseValidate, err
+	}
+
+	for _, step := range steps {
+		if m.onDo != nil {
+			if err := m.onDo(ctx, entity, step.from, step.to, step.action, opts...); err != nil {
+				return PhaseOnDo, fmt.Errorf("action '%s': %w: %w", step.action, ErrExecutionAction, err)
+			}
+		}
+
+		if onAction := m.actionsMap[step.action].do; onAction != nil {
+			if err := onAction(ctx, entity, opts...); err != nil {
+				return PhaseDo, fmt.Errorf("action '%s': %w: %w", step.action, ErrExecutionAction, err)
+			}
+			done.push(m, step.action, opts)
+		}
+	}
+
+	return "", nil
+}
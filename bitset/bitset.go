@@ -0,0 +1,121 @@
+// Package bitset provides the fixed-size state of multistates with more than
+// 64 flags.
+package bitset
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+const (
+	words = 4
+
+	// Size is the number of flags a Bitset holds.
+	Size = words * 64
+)
+
+// Bitset is an immutable set of flags. It is comparable, so it can be used as
+// a map key, and its zero value is the empty set.
+type Bitset struct {
+	w [words]uint64
+}
+
+func FromUint64(v uint64) Bitset {
+	return Bitset{w: [words]uint64{v}}
+}
+
+// Of returns a set of the bits.
+func Of(bits ...uint8) Bitset {
+	var b Bitset
+	for _, bit := range bits {
+		b = b.Set(bit)
+	}
+
+	return b
+}
+
+func (b Bitset) Has(bit uint8) bool {
+	return b.w[bit/64]&(1<<(bit%64)) != 0
+}
+
+func (b Bitset) Set(bit uint8) Bitset {
+	b.w[bit/64] |= 1 << (bit % 64)
+	return b
+}
+
+func (b Bitset) Clear(bit uint8) Bitset {
+	b.w[bit/64] &^= 1 << (bit % 64)
+	return b
+}
+
+func (b Bitset) Or(o Bitset) Bitset {
+	for i := range b.w {
+		b.w[i] |= o.w[i]
+	}
+	return b
+}
+
+func (b Bitset) And(o Bitset) Bitset {
+	for i := range b.w {
+		b.w[i] &= o.w[i]
+	}
+	return b
+}
+
+func (b Bitset) AndNot(o Bitset) Bitset {
+	for i := range b.w {
+		b.w[i] &^= o.w[i]
+	}
+	return b
+}
+
+// Less orders the sets as the numbers they represent.
+func (b Bitset) Less(o Bitset) bool {
+	for i := words - 1; i >= 0; i-- {
+		if b.w[i] != o.w[i] {
+			return b.w[i] < o.w[i]
+		}
+	}
+
+	return false
+}
+
+func (b Bitset) IsZero() bool {
+	return b == Bitset{}
+}
+
+// Uint64 returns the first 64 flags and whether the set has no other ones.
+func (b Bitset) Uint64() (uint64, bool) {
+	return b.w[0], b.w[1]|b.w[2]|b.w[3] == 0
+}
+
+// Bits returns the set bits in ascending order.
+func (b Bitset) Bits() []uint8 {
+	var res []uint8
+	for i, w := range b.w {
+		for w != 0 {
+			bit := bits.TrailingZeros64(w)
+			res = append(res, uint8(i*64+bit))
+			w &^= 1 << bit
+		}
+	}
+
+	return res
+}
+
+// String formats the set as a hexadecimal number.
+func (b Bitset) String() string {
+	sb := &strings.Builder{}
+	for i := words - 1; i >= 0; i-- {
+		if sb.Len() == 0 {
+			if b.w[i] != 0 || i == 0 {
+				fmt.Fprintf(sb, "0x%x", b.w[i])
+			}
+		} else {
+			fmt.Fprintf(sb, "%016x", b.w[i])
+		}
+	}
+
+	return sb.String()
+}
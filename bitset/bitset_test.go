@@ -0,0 +1,35 @@
+package bitset_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-qbit/multistate/bitset"
+)
+
+func TestBitset(t *testing.T) {
+	b := bitset.Of(1, 64, 200)
+
+	assert.True(t, b.Has(64))
+	assert.False(t, b.Has(65))
+	assert.Equal(t, []uint8{1, 64, 200}, b.Bits())
+	assert.Equal(t, "0x100000000000000000000000000000000010000000000000002", b.String())
+
+	_, fits := b.Uint64()
+	assert.False(t, fits)
+
+	v, fits := b.And(bitset.FromUint64(0xff)).Uint64()
+	assert.True(t, fits)
+	assert.Equal(t, uint64(2), v)
+
+	assert.Equal(t, bitset.Of(64, 200), b.Clear(1))
+	assert.Equal(t, bitset.Of(200), b.AndNot(bitset.Of(1, 64)))
+	assert.Equal(t, bitset.Of(1, 2, 64, 200), b.Or(bitset.Of(2)))
+	assert.True(t, b.AndNot(b).IsZero())
+	assert.Equal(t, "0x0", bitset.Bitset{}.String())
+
+	assert.True(t, bitset.Of(63).Less(bitset.Of(64)))
+	assert.False(t, bitset.Of(64).Less(bitset.Of(1, 63)))
+	assert.False(t, b.Less(b))
+}
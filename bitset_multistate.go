@@ -0,0 +1,44 @@
+package multistate
+
+import (
+	"context"
+
+	"github.com/go-qbit/multistate/bitset"
+)
+
+// BitsetEntity is an Entity whose state is a bitset.Bitset.
+type BitsetEntity = EntityOf[bitset.Bitset]
+
+type BitsetActionDoFunc = ActionDoFuncOf[bitset.Bitset]
+
+// BitsetMultistate is a multistate of up to bitset.Size flags. It shares the
+// action pipeline of Multistate, only enums, definitions, Analyze and path
+// planning are limited to 64 flags. The state space of many flags usually
+// can't be enumerated, so until Compile is called the actions are evaluated
+// on demand, as in the lazy mode without a cache.
+type BitsetMultistate struct {
+	machine[bitset.Bitset]
+}
+
+func NewBitset(emptyStateName string) *BitsetMultistate {
+	m := &BitsetMultistate{}
+	m.init(emptyStateName)
+	m.onDemand = true
+
+	return m
+}
+
+// GetHistory returns the history of the entity with the state flags resolved.
+func (m *BitsetMultistate) GetHistory(ctx context.Context, entityId interface{}) ([]BitsetHistoryEntry, error) {
+	return listHistory(ctx, &m.machine, entityId, func(r BitsetHistoryRecord, prevFlags, newFlags []StateFlag) BitsetHistoryEntry {
+		return BitsetHistoryEntry{r, prevFlags, newFlags}
+	})
+}
+
+type BitsetHistoryRecord = HistoryRecordOf[bitset.Bitset]
+
+type BitsetHistoryEntry struct {
+	BitsetHistoryRecord
+	PrevFlags []StateFlag
+	NewFlags  []StateFlag
+}
@@ -0,0 +1,387 @@
+// Command multistate-gen generates typed constants and DoAction wrappers for
+// a multistate, either from a structure used with multistate.NewFromStruct or
+// multistate.NewBitsetFromStruct, or from a declarative definition read by
+// multistate.Load:
+//
+//	//go:generate multistate-gen -type ContractImpl -prefix Contract
+//	//go:generate multistate-gen -def approval.yaml -prefix Approval
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/go-qbit/multistate"
+	"github.com/go-qbit/multistate/internal/naming"
+)
+
+const multistatePath = "github.com/go-qbit/multistate"
+
+type stateInfo struct {
+	Name string
+	Id   string
+	Bit  uint8
+}
+
+type actionInfo struct {
+	Name string
+	Id   string
+}
+
+type machine struct {
+	Package string
+	Prefix  string
+	Source  string
+	States  []stateInfo
+	Actions []actionInfo
+
+	// Bitset is set for the structures whose action methods return
+	// multistate.BitsetAction
+	Bitset bool
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("multistate-gen: ")
+
+	typeName := flag.String("type", "", "name of the structure used with multistate.NewFromStruct")
+	defFile := flag.String("def", "", "path to a YAML or JSON definition")
+	prefix := flag.String("prefix", "", "prefix for the generated identifiers")
+	output := flag.String("output", "", "output file name; default <type|def>_multistate.go")
+	pkgName := flag.String("package", os.Getenv("GOPACKAGE"), "package name of the generated file")
+	flag.Parse()
+
+	if (*typeName == "") == (*defFile == "") {
+		flag.Usage()
+		log.Fatal("exactly one of -type and -def must be set")
+	}
+
+	var m machine
+	var err error
+	if *typeName != "" {
+		m, err = loadStruct(".", *typeName)
+		if *output == "" {
+			*output = naming.CamelCaseToSnake(*typeName) + "_multistate.go"
+		}
+	} else {
+		m, err = loadDefinition(*defFile)
+		if *output == "" {
+			base := filepath.Base(*defFile)
+			*output = strings.TrimSuffix(base, filepath.Ext(base)) + "_multistate.go"
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *pkgName != "" {
+		m.Package = *pkgName
+	}
+	if m.Package == "" {
+		log.Fatal("cannot detect the package name, use -package")
+	}
+	m.Prefix = *prefix
+
+	src, err := generate(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadStruct finds the structure in the Go files of dir and collects its
+// multistate.State fields and Action* methods the same way
+// multistate.NewFromStruct and multistate.NewBitsetFromStruct do.
+func loadStruct(dir, typeName string) (machine, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return machine{}, err
+	}
+
+	m := machine{Source: "type " + typeName}
+	found := false
+	var actionTypes []string
+	fset := token.NewFileSet()
+
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			return machine{}, err
+		}
+
+		alias := multistateAlias(f)
+
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok || ts.Name.Name != typeName {
+						continue
+					}
+					st, ok := ts.Type.(*ast.StructType)
+					if !ok {
+						return machine{}, fmt.Errorf("%s is not a structure", typeName)
+					}
+
+					found = true
+					m.Package = f.Name.Name
+					if m.States, err = structStates(st, alias); err != nil {
+						return machine{}, err
+					}
+				}
+
+			case *ast.FuncDecl:
+				if decl.Recv == nil || len(decl.Recv.List) != 1 || receiverName(decl.Recv.List[0].Type) != typeName {
+					continue
+				}
+				if name := decl.Name.Name; strings.HasPrefix(name, "Action") && len(name) > len("Action") && decl.Name.IsExported() {
+					m.Actions = append(m.Actions, actionInfo{
+						Name: name[len("Action"):],
+						Id:   naming.CamelCaseToSnake(name[len("Action"):]),
+					})
+					actionTypes = append(actionTypes, resultType(decl.Type, alias))
+				}
+			}
+		}
+	}
+
+	if !found {
+		return machine{}, fmt.Errorf("structure %s not found", typeName)
+	}
+
+	for i, t := range actionTypes {
+		switch {
+		case t != "Action" && t != "BitsetAction":
+			return machine{}, fmt.Errorf("the action method Action%s must return multistate.Action or multistate.BitsetAction", m.Actions[i].Name)
+		case t != actionTypes[0]:
+			return machine{}, fmt.Errorf("the action methods of %s mix multistate.Action and multistate.BitsetAction", typeName)
+		}
+	}
+	m.Bitset = len(actionTypes) > 0 && actionTypes[0] == "BitsetAction"
+
+	if !m.Bitset {
+		for _, s := range m.States {
+			if s.Bit > 63 {
+				return machine{}, fmt.Errorf("bit %d of field '%s' doesn't fit in 64 bits, return multistate.BitsetAction from the action methods", s.Bit, s.Name)
+			}
+		}
+	}
+
+	sort.Slice(m.Actions, func(i, j int) bool { return m.Actions[i].Id < m.Actions[j].Id })
+
+	return m, nil
+}
+
+func multistateAlias(f *ast.File) string {
+	for _, imp := range f.Imports {
+		if path, _ := strconv.Unquote(imp.Path.Value); path == multistatePath {
+			if imp.Name != nil {
+				return imp.Name.Name
+			}
+			return "multistate"
+		}
+	}
+
+	return ""
+}
+
+func structStates(st *ast.StructType, alias string) ([]stateInfo, error) {
+	var res []stateInfo
+
+	for _, field := range st.Fields.List {
+		sel, ok := field.Type.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "State" {
+			continue
+		}
+		if x, ok := sel.X.(*ast.Ident); !ok || alias == "" || x.Name != alias {
+			continue
+		}
+
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			tagValue, _ := strconv.Unquote(field.Tag.Value)
+			tag = reflect.StructTag(tagValue)
+		}
+
+		for _, name := range field.Names {
+			strBit, exists := tag.Lookup("bit")
+			if !exists {
+				return nil, fmt.Errorf("missed required tag 'bit' for field '%s'", name.Name)
+			}
+			bit, err := strconv.ParseUint(strBit, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid 'bit' value for field '%s'", name.Name)
+			}
+
+			res = append(res, stateInfo{Name: name.Name, Id: naming.CamelCaseToSnake(name.Name), Bit: uint8(bit)})
+		}
+	}
+
+	return res, nil
+}
+
+// resultType returns the name of the single multistate type the function
+// returns, empty for other results.
+func resultType(f *ast.FuncType, alias string) string {
+	if f.Results == nil || len(f.Results.List) != 1 || len(f.Results.List[0].Names) > 1 {
+		return ""
+	}
+
+	sel, ok := f.Results.List[0].Type.(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	if x, ok := sel.X.(*ast.Ident); !ok || alias == "" || x.Name != alias {
+		return ""
+	}
+
+	return sel.Sel.Name
+}
+
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+
+	return ""
+}
+
+func loadDefinition(path string) (machine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return machine{}, err
+	}
+	defer f.Close()
+
+	def, err := multistate.ReadDefinition(f)
+	if err != nil {
+		return machine{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	m := machine{Source: filepath.Base(path)}
+	for _, s := range def.States {
+		m.States = append(m.States, stateInfo{Name: naming.SnakeToCamelCase(s.Id), Id: s.Id, Bit: s.Bit})
+	}
+	for _, a := range def.Actions {
+		m.Actions = append(m.Actions, actionInfo{Name: naming.SnakeToCamelCase(a.Id), Id: a.Id})
+	}
+	sort.Slice(m.Actions, func(i, j int) bool { return m.Actions[i].Id < m.Actions[j].Id })
+
+	return m, nil
+}
+
+var tmpl = template.Must(template.New("multistate").Parse(`// Code generated by multistate-gen from {{.Source}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/go-qbit/multistate"
+{{- if .Bitset}}
+	"github.com/go-qbit/multistate/bitset"
+{{- end}}
+)
+
+type {{.Prefix}}ActionId string
+
+const (
+{{- range .Actions}}
+	{{$.Prefix}}Action{{.Name}} {{$.Prefix}}ActionId = "{{.Id}}"
+{{- end}}
+)
+
+type {{.Prefix}}StateId string
+
+const (
+{{- range .States}}
+	{{$.Prefix}}State{{.Name}} {{$.Prefix}}StateId = "{{.Id}}"
+{{- end}}
+)
+
+{{if .Bitset -}}
+var (
+{{- range .States}}
+	{{$.Prefix}}Flag{{.Name}} = bitset.Of({{.Bit}})
+{{- end}}
+)
+
+// {{.Prefix}}Multistate wraps the multistate with typed action helpers.
+type {{.Prefix}}Multistate struct {
+	*multistate.BitsetMultistate
+}
+{{- else -}}
+const (
+{{- range .States}}
+	{{$.Prefix}}Flag{{.Name}} uint64 = 1 << {{.Bit}}
+{{- end}}
+)
+
+// {{.Prefix}}Multistate wraps the multistate with typed action helpers.
+type {{.Prefix}}Multistate struct {
+	*multistate.Multistate
+}
+{{- end}}
+
+func (m {{.Prefix}}Multistate) Do(ctx context.Context, entity {{.EntityType}}, action {{.Prefix}}ActionId, opts ...interface{}) ({{.StateType}}, error) {
+	return m.DoAction(ctx, entity, string(action), opts...)
+}
+{{range .Actions}}
+func (m {{$.Prefix}}Multistate) Do{{.Name}}(ctx context.Context, entity {{$.EntityType}}, opts ...interface{}) ({{$.StateType}}, error) {
+	return m.DoAction(ctx, entity, string({{$.Prefix}}Action{{.Name}}), opts...)
+}
+{{end}}`))
+
+func (m machine) EntityType() string {
+	if m.Bitset {
+		return "multistate.BitsetEntity"
+	}
+
+	return "multistate.Entity"
+}
+
+func (m machine) StateType() string {
+	if m.Bitset {
+		return "bitset.Bitset"
+	}
+
+	return "uint64"
+}
+
+func generate(m machine) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, m); err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("cannot format generated code: %w", err)
+	}
+
+	return src, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate_Struct(t *testing.T) {
+	m, err := loadStruct("testdata", "ContractImpl")
+	require.NoError(t, err)
+
+	assert.Equal(t, "contract", m.Package)
+	assert.Equal(t, []stateInfo{{"SignedA", "signed_a", 0}, {"SignedB", "signed_b", 1}}, m.States)
+	assert.Equal(t, []actionInfo{{"SignA", "sign_a"}, {"SignB", "sign_b"}}, m.Actions)
+
+	m.Prefix = "Contract"
+	src, err := generate(m)
+	require.NoError(t, err)
+
+	_, err = parser.ParseFile(token.NewFileSet(), "", src, 0)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(src), "// Code generated by multistate-gen from type ContractImpl; DO NOT EDIT.")
+	assert.Contains(t, string(src), `ContractActionSignA ContractActionId = "sign_a"`)
+	assert.Contains(t, string(src), `ContractStateSignedB ContractStateId = "signed_b"`)
+	assert.Contains(t, string(src), `ContractFlagSignedB uint64 = 1 << 1`)
+	assert.Contains(t, string(src), "func (m ContractMultistate) DoSignB(ctx context.Context, entity multistate.Entity, opts ...interface{}) (uint64, error) {\n"+
+		"\treturn m.DoAction(ctx, entity, string(ContractActionSignB), opts...)\n}")
+}
+
+func TestGenerate_BitsetStruct(t *testing.T) {
+	m, err := loadStruct("testdata", "ChecklistImpl")
+	require.NoError(t, err)
+
+	assert.True(t, m.Bitset)
+	assert.Equal(t, []stateInfo{{"Checked", "checked", 70}, {"Approved", "approved", 200}}, m.States)
+
+	src, err := generate(m)
+	require.NoError(t, err)
+
+	_, err = parser.ParseFile(token.NewFileSet(), "", src, 0)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(src), `"github.com/go-qbit/multistate/bitset"`)
+	assert.Contains(t, string(src), "FlagApproved = bitset.Of(200)")
+	assert.Contains(t, string(src), "\t*multistate.BitsetMultistate\n")
+	assert.Contains(t, string(src), "func (m Multistate) DoCheck(ctx context.Context, entity multistate.BitsetEntity, opts ...interface{}) (bitset.Bitset, error) {")
+
+	_, err = loadStruct("testdata", "MixedImpl")
+	assert.EqualError(t, err, "the action methods of MixedImpl mix multistate.Action and multistate.BitsetAction")
+
+	_, err = loadStruct("testdata", "WideImpl")
+	assert.EqualError(t, err, "bit 70 of field 'Checked' doesn't fit in 64 bits, return multistate.BitsetAction from the action methods")
+}
+
+func TestGenerate_Definition(t *testing.T) {
+	m, err := loadDefinition("testdata/approval.yaml")
+	require.NoError(t, err)
+
+	m.Package = "approval"
+	src, err := generate(m)
+	require.NoError(t, err)
+
+	_, err = parser.ParseFile(token.NewFileSet(), "", src, 0)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(src), `StateApprovedByManager StateId = "approved-by-manager"`)
+	assert.Contains(t, string(src), `FlagApprovedByManager uint64 = 1 << 3`)
+	assert.Contains(t, string(src), "func (m Multistate) DoApprove(")
+}
+
+func TestLoadStruct_NotFound(t *testing.T) {
+	_, err := loadStruct("testdata", "Missing")
+	assert.EqualError(t, err, "structure Missing not found")
+}
@@ -0,0 +1,39 @@
+package contract
+
+import (
+	"github.com/go-qbit/multistate"
+	. "github.com/go-qbit/multistate/expr"
+)
+
+type ChecklistImpl struct {
+	Checked  multistate.State `bit:"70"`
+	Approved multistate.State `bit:"200"`
+}
+
+func (c *ChecklistImpl) ActionCheck() multistate.BitsetAction {
+	return multistate.BitsetAction{From: Not(c.Checked), Set: multistate.States{c.Checked}}
+}
+
+func (c *ChecklistImpl) ActionApprove() multistate.BitsetAction {
+	return multistate.BitsetAction{From: And(c.Checked, Not(c.Approved)), Set: multistate.States{c.Approved}}
+}
+
+type MixedImpl struct {
+	Checked multistate.State `bit:"1"`
+}
+
+func (c *MixedImpl) ActionCheck() multistate.BitsetAction {
+	return multistate.BitsetAction{Set: multistate.States{c.Checked}}
+}
+
+func (c *MixedImpl) ActionUncheck() multistate.Action {
+	return multistate.Action{Reset: multistate.States{c.Checked}}
+}
+
+type WideImpl struct {
+	Checked multistate.State `bit:"70"`
+}
+
+func (c *WideImpl) ActionCheck() multistate.Action {
+	return multistate.Action{Set: multistate.States{c.Checked}}
+}
@@ -0,0 +1,24 @@
+package contract
+
+import (
+	ms "github.com/go-qbit/multistate"
+	. "github.com/go-qbit/multistate/expr"
+)
+
+type ContractImpl struct {
+	SignedA ms.State `bit:"0" caption:"Signed A"`
+	SignedB ms.State `bit:"1"`
+	Note    string
+}
+
+func (c *ContractImpl) ActionSignA() ms.Action {
+	return ms.Action{From: Not(c.SignedA), Set: ms.States{c.SignedA}}
+}
+
+func (c *ContractImpl) ActionSignB() ms.Action {
+	return ms.Action{From: Not(c.SignedB), Set: ms.States{c.SignedB}}
+}
+
+func (c *ContractImpl) actionHidden() ms.Action {
+	return ms.Action{}
+}
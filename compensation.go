@@ -0,0 +1,57 @@
+package multistate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SetCompensation sets the function undoing the side effects of the do
+// callback of the action. When a later step of DoAction fails, including
+// SetState and EndAction, the compensations of the callbacks that succeeded
+// run in reverse order.
+func (m *machine[S]) SetCompensation(action string, compensate ActionDoFuncOf[S]) error {
+	a, exists := m.actionsMap[action]
+	if !exists {
+		return fmt.Errorf("action '%s' doesn't exists", action)
+	}
+	a.compensate = compensate
+	a.compensateName = ""
+
+	return nil
+}
+
+func (m *machine[S]) MustSetCompensation(action string, compensate ActionDoFuncOf[S]) {
+	if err := m.SetCompensation(action, compensate); err != nil {
+		panic(err)
+	}
+}
+
+type compensationStep struct {
+	action string
+	opts   []interface{}
+}
+
+type compensations[S StateValue] []compensationStep
+
+// push remembers that the do callback of the action succeeded.
+func (c *compensations[S]) push(m *machine[S], action string, opts []interface{}) {
+	if m.actionsMap[action].compensate != nil {
+		*c = append(*c, compensationStep{action, opts})
+	}
+}
+
+// run compensates the steps in reverse order and clears them. It returns the
+// joined errors of the compensations.
+func (c *compensations[S]) run(ctx context.Context, m *machine[S], entity EntityOf[S]) error {
+	var errs []error
+	for i := len(*c) - 1; i >= 0; i-- {
+		step := (*c)[i]
+		if err := m.actionsMap[step.action].compensate(ctx, entity, step.opts...); err != nil {
+			errs = append(errs, fmt.Errorf("compensate '%s': %w", step.action, err))
+		}
+	}
+	*c = nil
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,311 @@
+package multistate
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/go-qbit/multistate/expr"
+)
+
+// Definition is a stable, serializable description of a Multistate.
+type Definition struct {
+	EmptyStateName string                 `json:"empty_state_name"`
+	States         []StateDefinition      `json:"states"`
+	Enums          []EnumDefinition       `json:"enums,omitempty"`
+	Actions        []ActionDefinition     `json:"actions"`
+	Clusters       []ClusterDefinition    `json:"clusters,omitempty"`
+	Transitions    []TransitionDefinition `json:"transitions,omitempty"`
+}
+
+type StateDefinition struct {
+	Id      string `json:"id"`
+	Bit     uint8  `json:"bit"`
+	Caption string `json:"caption"`
+}
+
+type EnumDefinition struct {
+	Id       string   `json:"id"`
+	FirstBit uint8    `json:"first_bit"`
+	Width    uint8    `json:"width"`
+	Caption  string   `json:"caption"`
+	Values   []string `json:"values"`
+}
+
+// ActionDefinition lists the states to set and reset by id. An enum value is
+// set as "risk=high", an enum id in Reset clears the field. A missing From
+// makes the action available from the empty state only.
+type ActionDefinition struct {
+	Id         string    `json:"id"`
+	Caption    string    `json:"caption"`
+	From       expr.Node `json:"from"`
+	Set        []string  `json:"set,omitempty"`
+	Reset      []string  `json:"reset,omitempty"`
+	OnDo       string    `json:"on_do,omitempty"`
+	Availabler string    `json:"availabler,omitempty"`
+	Automatic  bool      `json:"automatic,omitempty"`
+	Compensate string    `json:"compensate,omitempty"`
+}
+
+type ClusterDefinition struct {
+	Caption string    `json:"caption"`
+	Expr    expr.Node `json:"expr"`
+}
+
+type TransitionDefinition struct {
+	From   uint64 `json:"from"`
+	To     uint64 `json:"to"`
+	Action string `json:"action"`
+}
+
+// Registry binds the callback names used in a Definition to their
+// implementations.
+type Registry struct {
+	OnDo          OnDoCallback
+	Actions       map[string]ActionDoFunc
+	Availablers   map[string]Availabler
+	Compensations map[string]ActionDoFunc
+}
+
+// Export describes the multistate. The OnDo and Compensate names of an action
+// are the names it was loaded with, or the action id for callbacks set from Go
+// code. The
+// transitions are left out in the lazy mode, they are only informative.
+func (m *Multistate) Export() (Definition, error) {
+	def := Definition{
+		EmptyStateName: m.emptyStateName,
+		States:         []StateDefinition{},
+		Actions:        []ActionDefinition{},
+	}
+
+	for _, flag := range m.GetAllStateFlags() {
+		def.States = append(def.States, StateDefinition{
+			Id:      flag.Id,
+			Bit:     flag.Bit,
+			Caption: flag.Caption,
+		})
+	}
+
+	for _, e := range m.enums {
+		def.Enums = append(def.Enums, EnumDefinition{
+			Id:       e.id,
+			FirstBit: e.shift,
+			Width:    uint8(bits.OnesCount64(e.mask)),
+			Caption:  e.caption,
+			Values:   e.Values(),
+		})
+	}
+
+	for _, id := range m.sortedActionIds() {
+		a := m.actionsMap[id]
+
+		from, err := expr.ToNode(a.from)
+		if err != nil {
+			return Definition{}, fmt.Errorf("action '%s': %w", id, err)
+		}
+
+		ad := ActionDefinition{
+			Id:        a.id,
+			Caption:   a.caption,
+			From:      from,
+			Automatic: a.automatic,
+		}
+		for _, v := range a.set {
+			if e := m.enumByBits(v); e != nil {
+				ad.Set = append(ad.Set, e.id+"="+e.Value(v))
+				continue
+			}
+			ad.Set = append(ad.Set, m.statesBitsMap[uint8(bits.TrailingZeros64(v))].id)
+		}
+		for _, v := range a.reset {
+			if e := m.enumByBits(v); e != nil {
+				// The reset added by an enum setter is implied by the set entry
+				if a.setMask()&e.mask == 0 {
+					ad.Reset = append(ad.Reset, e.id)
+				}
+				continue
+			}
+			if v == 0 {
+				continue
+			}
+			ad.Reset = append(ad.Reset, m.statesBitsMap[uint8(bits.TrailingZeros64(v))].id)
+		}
+		if a.do != nil {
+			ad.OnDo = a.doName
+			if ad.OnDo == "" {
+				ad.OnDo = a.id
+			}
+		}
+		if a.compensate != nil {
+			ad.Compensate = a.compensateName
+			if ad.Compensate == "" {
+				ad.Compensate = a.id
+			}
+		}
+		if a.availabler != nil {
+			ad.Availabler = a.availabler.String()
+		}
+
+		def.Actions = append(def.Actions, ad)
+	}
+
+	for _, c := range m.clusters {
+		e, err := expr.ToNode(c.expression)
+		if err != nil {
+			return Definition{}, fmt.Errorf("cluster '%s': %w", c.name, err)
+		}
+		def.Clusters = append(def.Clusters, ClusterDefinition{Caption: c.name, Expr: e})
+	}
+
+	for _, c := range m.GetConnections() {
+		def.Transitions = append(def.Transitions, TransitionDefinition{From: c.From, To: c.To, Action: c.Action})
+	}
+
+	return def, nil
+}
+
+func (m *Multistate) MarshalJSON() ([]byte, error) {
+	def, err := m.Export()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(def)
+}
+
+// NewFromDefinition builds and compiles a multistate from the definition,
+// binding callbacks by name from the registry. The transitions of the
+// definition are recomputed by Compile.
+func NewFromDefinition(def Definition, registry Registry) (*Multistate, error) {
+	mst := New(def.EmptyStateName)
+	mst.SetOnDoCallback(registry.OnDo)
+
+	for _, s := range def.States {
+		if _, err := mst.AddState(s.Bit, s.Id, s.Caption); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, e := range def.Enums {
+		if _, err := mst.AddEnum(e.FirstBit, e.Width, e.Id, e.Caption, e.Values...); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range def.Actions {
+		var from expr.Expression = expr.Empty()
+		if a.From.Op != "" {
+			var err error
+			if from, err = a.From.Expression(mst.ResolveState); err != nil {
+				return nil, fmt.Errorf("action '%s': %w", a.Id, err)
+			}
+		}
+
+		set, err := mst.statesByIds(a.Set)
+		if err != nil {
+			return nil, fmt.Errorf("action '%s': %w", a.Id, err)
+		}
+
+		reset, err := mst.statesByIds(a.Reset)
+		if err != nil {
+			return nil, fmt.Errorf("action '%s': %w", a.Id, err)
+		}
+
+		var onDo ActionDoFunc
+		if a.OnDo != "" {
+			if onDo = registry.Actions[a.OnDo]; onDo == nil {
+				return nil, fmt.Errorf("action '%s': unknown callback '%s'", a.Id, a.OnDo)
+			}
+		}
+
+		var compensate ActionDoFunc
+		if a.Compensate != "" {
+			if compensate = registry.Compensations[a.Compensate]; compensate == nil {
+				return nil, fmt.Errorf("action '%s': unknown compensation '%s'", a.Id, a.Compensate)
+			}
+		}
+
+		var avail Availabler
+		if a.Availabler != "" {
+			if avail = registry.Availablers[a.Availabler]; avail == nil {
+				return nil, fmt.Errorf("action '%s': unknown availabler '%s'", a.Id, a.Availabler)
+			}
+		}
+
+		if err := mst.AddAction(a.Id, a.Caption, from, set, reset, onDo, avail); err != nil {
+			return nil, err
+		}
+		mst.actionsMap[a.Id].doName = a.OnDo
+		mst.actionsMap[a.Id].compensate = compensate
+		mst.actionsMap[a.Id].compensateName = a.Compensate
+		if a.Automatic {
+			if err := mst.SetAutomatic(a.Id); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, c := range def.Clusters {
+		if c.Expr.Op == "" {
+			return nil, fmt.Errorf("cluster '%s': missing expr", c.Caption)
+		}
+		e, err := c.Expr.Expression(mst.ResolveState)
+		if err != nil {
+			return nil, fmt.Errorf("cluster '%s': %w", c.Caption, err)
+		}
+		mst.AddCluster(c.Caption, e)
+	}
+
+	if err := mst.Compile(); err != nil {
+		return nil, err
+	}
+
+	return mst, nil
+}
+
+// ResolveState returns the state or the enum registered with the id. It fits
+// the expr.Resolver signature.
+func (m *machine[S]) ResolveState(id string) (expr.Expression, error) {
+	if e := m.enumById(id); e != nil {
+		return e, nil
+	}
+
+	s, exists := m.statesMap[id]
+	if !exists {
+		return nil, fmt.Errorf("state id '%s': %w", id, ErrInvalidState)
+	}
+
+	return s, nil
+}
+
+func (m *Multistate) statesByIds(ids []string) (States, error) {
+	res := make(States, len(ids))
+	for i, id := range ids {
+		if enumId, value, found := strings.Cut(id, "="); found {
+			e := m.enumById(enumId)
+			if e == nil {
+				return nil, fmt.Errorf("enum id '%s': %w", enumId, ErrInvalidState)
+			}
+			v, err := e.Encode(value)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = enumValue{e, v}
+			continue
+		}
+
+		if e := m.enumById(id); e != nil {
+			res[i] = e
+			continue
+		}
+
+		s, exists := m.statesMap[id]
+		if !exists {
+			return nil, fmt.Errorf("state id '%s': %w", id, ErrInvalidState)
+		}
+		res[i] = s
+	}
+
+	return res, nil
+}
@@ -0,0 +1,278 @@
+package multistate_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-qbit/multistate"
+	. "github.com/go-qbit/multistate/expr"
+)
+
+type testAvailabler struct {
+	name      string
+	available bool
+}
+
+func (a testAvailabler) String() string                   { return a.name }
+func (a testAvailabler) IsAvailable(context.Context) bool { return a.available }
+
+func TestMultistate_Export(t *testing.T) {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B")
+
+	mst.MustAddAction("sign_a", "Sign A", Empty(), multistate.States{signedA}, nil,
+		func(context.Context, multistate.Entity, ...interface{}) error { return nil }, nil)
+	mst.MustAddAction("sign_b", "Sign B", And(signedA, Not(signedB)), multistate.States{signedB}, multistate.States{signedA},
+		nil, testAvailabler{"manager", true})
+	mst.AddCluster("Signed", Or(signedA, signedB))
+	mst.MustCompile()
+
+	data, err := json.Marshal(mst)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"empty_state_name": "New",
+		"states": [
+			{"id": "signed_a", "bit": 0, "caption": "Signed A"},
+			{"id": "signed_b", "bit": 1, "caption": "Signed B"}
+		],
+		"actions": [
+			{"id": "sign_a", "caption": "Sign A", "from": {"op": "empty"}, "set": ["signed_a"], "on_do": "sign_a"},
+			{"id": "sign_b", "caption": "Sign B",
+			 "from": {"op": "and", "args": [{"op": "state", "state": "signed_a"}, {"op": "not", "args": [{"op": "state", "state": "signed_b"}]}]},
+			 "set": ["signed_b"], "reset": ["signed_a"], "availabler": "manager"}
+		],
+		"clusters": [
+			{"caption": "Signed", "expr": {"op": "or", "args": [{"op": "state", "state": "signed_a"}, {"op": "state", "state": "signed_b"}]}}
+		],
+		"transitions": [
+			{"from": 0, "to": 1, "action": "sign_a"},
+			{"from": 1, "to": 2, "action": "sign_b"}
+		]
+	}`, string(data))
+
+	var def multistate.Definition
+	require.NoError(t, json.Unmarshal(data, &def))
+
+	_, err = multistate.NewFromDefinition(def, multistate.Registry{})
+	assert.EqualError(t, err, "action 'sign_a': unknown callback 'sign_a'")
+
+	var called bool
+	loaded, err := multistate.NewFromDefinition(def, multistate.Registry{
+		Actions: map[string]multistate.ActionDoFunc{
+			"sign_a": func(context.Context, multistate.Entity, ...interface{}) error {
+				called = true
+				return nil
+			},
+		},
+		Availablers: map[string]multistate.Availabler{"manager": testAvailabler{"manager", true}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, mst.GetConnections(), loaded.GetConnections())
+
+	e := &testEntity{}
+	_, err = loaded.DoAction(context.Background(), e, "sign_a")
+	require.NoError(t, err)
+	assert.True(t, called)
+
+	reexported, err := json.Marshal(loaded)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(reexported))
+}
+
+const testDefinitionYAML = `
+empty_state_name: Draft
+states:
+  - {id: signed_a, bit: 0, caption: Signed A}
+  - {id: signed_b, bit: 1, caption: Signed B}
+  - {id: signed_c, bit: 2, caption: Signed C}
+actions:
+  - id: sign_a
+    caption: Sign A
+    from: "!signed_a & !signed_c"
+    set: [signed_a]
+  - id: sign_b
+    caption: Sign B
+    from: "!signed_b & !signed_c"
+    set: [signed_b]
+  - id: sign_c
+    caption: Sign C
+    from: "(signed_a | signed_b) & !signed_c"
+    set: [signed_c]
+    reset: [signed_a, signed_b]
+    on_do: notify_legal
+    availabler: manager
+clusters:
+  - caption: Done
+    expr: signed_c
+`
+
+func TestLoad(t *testing.T) {
+	var notified int
+	registry := multistate.Registry{
+		Actions: map[string]multistate.ActionDoFunc{
+			"notify_legal": func(context.Context, multistate.Entity, ...interface{}) error {
+				notified++
+				return nil
+			},
+		},
+		Availablers: map[string]multistate.Availabler{"manager": testAvailabler{"manager", true}},
+	}
+
+	mst, err := multistate.Load(strings.NewReader(testDefinitionYAML), registry)
+	require.NoError(t, err)
+
+	assert.Equal(t, []uint64{1, 2, 3, 4}, mst.GetMultistatesByStateIds("signed_a", "signed_b", "signed_c"))
+	assert.Equal(t, "Draft", mst.GetStateName(0))
+
+	e := &testEntity{}
+	for _, action := range []string{"sign_b", "sign_a", "sign_c"} {
+		_, err := mst.DoAction(context.Background(), e, action)
+		require.NoError(t, err, action)
+	}
+	assert.Equal(t, uint64(4), e.state)
+	assert.Equal(t, 1, notified)
+
+	def, err := mst.Export()
+	require.NoError(t, err)
+	data, err := json.Marshal(def)
+	require.NoError(t, err)
+
+	fromJSON, err := multistate.Load(bytes.NewReader(data), registry)
+	require.NoError(t, err)
+	assert.Equal(t, mst.GetConnections(), fromJSON.GetConnections())
+}
+
+func TestLoad_Enum(t *testing.T) {
+	mst, err := multistate.Load(strings.NewReader(`
+empty_state_name: New
+states:
+  - {id: signed, bit: 0, caption: Signed}
+enums:
+  - {id: risk, first_bit: 1, width: 2, caption: Risk, values: [low, medium, high]}
+actions:
+  - {id: assess_low, caption: Assess low, from: "!signed & !risk", set: ["risk=low"]}
+  - {id: escalate, caption: Escalate, from: "risk == low | risk == medium", set: ["risk=high"]}
+  - {id: sign, caption: Sign, from: "!signed & !risk == high", set: [signed]}
+  - {id: clear, caption: Clear, from: "risk & !signed", reset: [risk]}
+`), multistate.Registry{})
+	require.NoError(t, err)
+
+	e := &testEntity{}
+	for _, action := range []string{"assess_low", "sign"} {
+		_, err := mst.DoAction(context.Background(), e, action)
+		require.NoError(t, err, action)
+	}
+	assert.Equal(t, uint64(3), e.state)
+
+	e.state = 6
+	_, err = mst.DoAction(context.Background(), e, "clear")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), e.state)
+
+	def, err := mst.Export()
+	require.NoError(t, err)
+	assert.Equal(t, []multistate.EnumDefinition{{Id: "risk", FirstBit: 1, Width: 2, Caption: "Risk", Values: []string{"low", "medium", "high"}}}, def.Enums)
+	assert.Equal(t, []string{"risk"}, def.Actions[1].Reset)
+	assert.Equal(t, []string{"risk=high"}, def.Actions[2].Set)
+	assert.Nil(t, def.Actions[2].Reset)
+	assert.Equal(t, Node{Op: OpOr, Args: []Node{
+		{Op: OpEnum, State: "risk", Value: "low"},
+		{Op: OpEnum, State: "risk", Value: "medium"},
+	}}, def.Actions[2].From)
+
+	data, err := json.Marshal(def)
+	require.NoError(t, err)
+	loaded, err := multistate.Load(bytes.NewReader(data), multistate.Registry{})
+	require.NoError(t, err)
+	assert.Equal(t, mst.GetConnections(), loaded.GetConnections())
+
+	for text, msg := range map[string]string{
+		`actions: [{id: a, caption: A, from: empty, set: ["risk=none"]}]`: `action 'a': enum 'risk' has no value 'none': invalid_state_error`,
+		`actions: [{id: a, caption: A, from: empty, set: ["level=one"]}]`: `action 'a': enum id 'level': invalid_state_error`,
+		`actions: [{id: a, caption: A, from: "risk == none"}]`:            `action 'a': enum 'risk' has no value 'none': invalid_state_error`,
+	} {
+		_, err := multistate.Load(strings.NewReader("enums: [{id: risk, first_bit: 0, width: 2, caption: Risk, values: [low]}]\n"+text), multistate.Registry{})
+		assert.EqualError(t, err, msg, text)
+	}
+}
+
+func TestLoad_MissingFrom(t *testing.T) {
+	mst, err := multistate.Load(strings.NewReader(`
+states: [{id: signed, bit: 0, caption: Signed}]
+actions: [{id: sign, caption: Sign, set: [signed]}]
+`), multistate.Registry{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sign"}, mst.GetStateActions(context.Background(), 0))
+	assert.Empty(t, mst.GetStateActions(context.Background(), 1))
+}
+
+func TestLoad_Compensation(t *testing.T) {
+	const text = `
+states: [{id: charged, bit: 0, caption: Charged}]
+actions: [{id: charge, caption: Charge, from: "!charged", set: [charged], on_do: charge_card, compensate: refund_card}]
+`
+	var log []string
+	step := func(name string) multistate.ActionDoFunc {
+		return func(context.Context, multistate.Entity, ...interface{}) error {
+			log = append(log, name)
+			return nil
+		}
+	}
+	registry := multistate.Registry{
+		Actions:       map[string]multistate.ActionDoFunc{"charge_card": step("charge")},
+		Compensations: map[string]multistate.ActionDoFunc{"refund_card": step("refund")},
+	}
+
+	_, err := multistate.Load(strings.NewReader(text), multistate.Registry{Actions: registry.Actions})
+	assert.EqualError(t, err, "action 'charge': unknown compensation 'refund_card'")
+
+	mst, err := multistate.Load(strings.NewReader(text), registry)
+	require.NoError(t, err)
+
+	_, err = mst.DoAction(context.Background(), &failingSetEntity{}, "charge")
+	assert.ErrorIs(t, err, multistate.ErrSetState)
+	assert.Equal(t, []string{"charge", "refund"}, log)
+
+	def, err := mst.Export()
+	require.NoError(t, err)
+	assert.Equal(t, "refund_card", def.Actions[0].Compensate)
+
+	data, err := json.Marshal(def)
+	require.NoError(t, err)
+	loaded, err := multistate.Load(bytes.NewReader(data), registry)
+	require.NoError(t, err)
+	reexported, err := json.Marshal(loaded)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(reexported))
+
+	mst.MustSetCompensation("charge", step("refund"))
+	def, err = mst.Export()
+	require.NoError(t, err)
+	assert.Equal(t, "charge", def.Actions[0].Compensate)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	for text, msg := range map[string]string{
+		`states: [{id: signed_a, bit: 0, caption: A, color: red}]`:      `invalid definition: json: unknown field "color"`,
+		`actions: [{id: sign_a, caption: A, from: "signed_a &"}]`:       `invalid definition: expression 'signed_a &', position 11: unexpected end of expression`,
+		`actions: [{id: sign_a, caption: A, from: "signed_x"}]`:         `action 'sign_a': state id 'signed_x': invalid_state_error`,
+		`actions: [{id: sign_a, caption: A, from: empty, on_do: x}]`:    `action 'sign_a': unknown callback 'x'`,
+		`actions: [{id: sign_a, caption: A, from: {op: state, id: a}}]`: `invalid definition: json: unknown field "id"`,
+		`actions: [{id: sign_a, caption: A, from: ""}]`:                 `invalid definition: empty expression`,
+		"actions:\n  - id: sign_a\n    from: !signed_a":                 `invalid definition: line 3: the value '!signed_a' must be quoted`,
+		`clusters: [{caption: Done}]`:                                   `cluster 'Done': missing expr`,
+	} {
+		_, err := multistate.Load(strings.NewReader(text), multistate.Registry{})
+		assert.EqualError(t, err, msg, text)
+	}
+}
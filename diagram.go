@@ -0,0 +1,105 @@
+package multistate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	mermaidReplacer  = strings.NewReplacer(`"`, "#quot;", ";", "#59;", "\n", "<br/>")
+	plantUMLReplacer = strings.NewReplacer(`"`, "'", "\n", `\n`)
+)
+
+// WriteMermaid writes the compiled graph as a Mermaid state diagram. Clusters
+// become composite states.
+func (m *machine[S]) WriteMermaid(w io.Writer) error {
+	if m.IsLazy() {
+		return ErrLazyMode
+	}
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "stateDiagram-v2")
+	m.writeDiagramStates(func(indent string, state S) {
+		fmt.Fprintf(bw, "%sstate \"%s\" as s%v\n", indent, mermaidReplacer.Replace(m.GetStateName(state)), state)
+	}, func(c *cluster) {
+		fmt.Fprintf(bw, "    state \"%s\" as cluster_%d {\n", mermaidReplacer.Replace(c.name), c.id)
+	}, func() {
+		fmt.Fprintln(bw, "    }")
+	})
+	m.writeDiagramTransitions(func(from, to, label string) {
+		if label == "" {
+			fmt.Fprintf(bw, "    %s --> %s\n", from, to)
+		} else {
+			fmt.Fprintf(bw, "    %s --> %s : %s\n", from, to, mermaidReplacer.Replace(label))
+		}
+	})
+
+	return bw.Flush()
+}
+
+// WritePlantUML writes the compiled graph as a PlantUML state diagram.
+// Clusters become composite states.
+func (m *machine[S]) WritePlantUML(w io.Writer) error {
+	if m.IsLazy() {
+		return ErrLazyMode
+	}
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "@startuml")
+	m.writeDiagramStates(func(indent string, state S) {
+		fmt.Fprintf(bw, "%sstate \"%s\" as s%v\n", indent, plantUMLReplacer.Replace(m.GetStateName(state)), state)
+	}, func(c *cluster) {
+		fmt.Fprintf(bw, "    state \"%s\" as cluster_%d {\n", plantUMLReplacer.Replace(c.name), c.id)
+	}, func() {
+		fmt.Fprintln(bw, "    }")
+	})
+	m.writeDiagramTransitions(func(from, to, label string) {
+		if label == "" {
+			fmt.Fprintf(bw, "    %s --> %s\n", from, to)
+		} else {
+			fmt.Fprintf(bw, "    %s --> %s : %s\n", from, to, plantUMLReplacer.Replace(label))
+		}
+	})
+	fmt.Fprintln(bw, "@enduml")
+
+	return bw.Flush()
+}
+
+func (m *machine[S]) writeDiagramStates(writeState func(indent string, state S), openCluster func(c *cluster), closeCluster func()) {
+	clusterStates := map[*cluster][]S{}
+	for _, state := range m.sortedStates() {
+		if c := m.stateClusterMap[state]; c != nil {
+			clusterStates[c] = append(clusterStates[c], state)
+		} else {
+			writeState("    ", state)
+		}
+	}
+
+	for i := range m.clusters {
+		c := &m.clusters[i]
+		if len(clusterStates[c]) == 0 {
+			continue
+		}
+
+		openCluster(c)
+		for _, state := range clusterStates[c] {
+			writeState("        ", state)
+		}
+		closeCluster()
+	}
+}
+
+func (m *machine[S]) writeDiagramTransitions(writeTransition func(from, to, label string)) {
+	var empty S
+	if _, exists := m.statesActions[empty]; exists {
+		writeTransition("[*]", fmt.Sprintf("s%v", empty), "")
+	}
+
+	for _, c := range m.GetConnections() {
+		writeTransition(fmt.Sprintf("s%v", c.From), fmt.Sprintf("s%v", c.To), m.actionsMap[c.Action].caption)
+	}
+}
@@ -0,0 +1,274 @@
+package multistate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-qbit/multistate/expr"
+)
+
+// Enum is a field of adjacent bits holding one of the declared values. The
+// zero bits mean that no value is assigned, the values are numbered from 1.
+type Enum struct {
+	id      string
+	caption string
+	shift   uint8
+	mask    uint64
+	values  []string
+}
+
+// AddEnum registers a field occupying width bits starting from firstBit.
+func (m *Multistate) AddEnum(firstBit, width uint8, id, caption string, values ...string) (*Enum, error) {
+	if !reStateAction.MatchString(id) {
+		return nil, fmt.Errorf("invalid characters in enum id '%s', must be %s", id, reStateAction.String())
+	}
+
+	if width == 0 || int(firstBit)+int(width) > 64 {
+		return nil, fmt.Errorf("enum '%s' must fit in 64 bits", id)
+	}
+
+	maxValue := uint64(1)<<width - 1
+	if len(values) == 0 || uint64(len(values)) > maxValue {
+		return nil, fmt.Errorf("enum '%s' must have from 1 to %d values", id, maxValue)
+	}
+
+	for i, v := range values {
+		if !reStateAction.MatchString(v) {
+			return nil, fmt.Errorf("invalid characters in enum value '%s', must be %s", v, reStateAction.String())
+		}
+		for _, prev := range values[:i] {
+			if prev == v {
+				return nil, fmt.Errorf("enum '%s' has duplicate value '%s'", id, v)
+			}
+		}
+	}
+
+	if _, exists := m.statesMap[id]; id == "empty" || id == "any" || exists || m.enumById(id) != nil {
+		return nil, fmt.Errorf("state '%s' already exists", id)
+	}
+
+	e := &Enum{
+		id:      id,
+		caption: caption,
+		shift:   firstBit,
+		mask:    maxValue << firstBit,
+		values:  values,
+	}
+
+	for bit := firstBit; bit < firstBit+width; bit++ {
+		if _, exists := m.statesBitsMap[bit]; exists || m.enumBits()&(1<<bit) != 0 {
+			return nil, fmt.Errorf("bit '%d' already busy", bit)
+		}
+	}
+
+	m.enums = append(m.enums, e)
+
+	return e, nil
+}
+
+func (m *Multistate) MustAddEnum(firstBit, width uint8, id, caption string, values ...string) *Enum {
+	e, err := m.AddEnum(firstBit, width, id, caption, values...)
+	if err != nil {
+		panic(err)
+	}
+
+	return e
+}
+
+func (e *Enum) GetId() string {
+	return e.id
+}
+
+func (e *Enum) Values() []string {
+	return append([]string(nil), e.values...)
+}
+
+// Mask returns the bits of the field.
+func (e *Enum) Mask() uint64 {
+	return e.mask
+}
+
+// Encode returns the bits of the field holding the value.
+func (e *Enum) Encode(value string) (uint64, error) {
+	for i, v := range e.values {
+		if v == value {
+			return uint64(i+1) << e.shift, nil
+		}
+	}
+
+	return 0, fmt.Errorf("enum '%s' has no value '%s': %w", e.id, value, ErrInvalidState)
+}
+
+// GetStateId and Eval make the field usable in the reset list of an action,
+// clearing its value, and in expressions, where it holds for any value.
+func (e *Enum) GetStateId() string {
+	return e.id
+}
+
+func (e *Enum) Eval(v uint64) bool {
+	return v&e.mask != 0
+}
+
+// EnumValue implements expr.EnumField, so "risk == high" can be parsed.
+func (e *Enum) EnumValue(value string) (expr.Expression, error) {
+	v, err := e.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return enumIs{e, v}, nil
+}
+
+// Is returns the expression testing the field for the value. It panics if
+// the value isn't declared.
+func (e *Enum) Is(value string) enumIs {
+	return enumIs{e, e.mustEncode(value)}
+}
+
+// Set returns the state to pass in the set list of an action to assign the
+// value. It panics if the value isn't declared.
+func (e *Enum) Set(value string) State {
+	return enumValue{e, e.mustEncode(value)}
+}
+
+// Value returns the value of the field in the state, empty if none is
+// assigned.
+func (e *Enum) Value(state uint64) string {
+	n := (state & e.mask) >> e.shift
+	if n == 0 || n > uint64(len(e.values)) {
+		return ""
+	}
+
+	return e.values[n-1]
+}
+
+func (e *Enum) mustEncode(value string) uint64 {
+	v, err := e.Encode(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// valid reports whether the field holds no value or a declared one.
+func (e *Enum) valid(state uint64) bool {
+	return (state&e.mask)>>e.shift <= uint64(len(e.values))
+}
+
+type enumIs struct {
+	enum  *Enum
+	value uint64
+}
+
+func (e enumIs) Eval(v uint64) bool {
+	return v&e.enum.mask == e.value
+}
+
+func (e enumIs) GetEnumId() string {
+	return e.enum.id
+}
+
+func (e enumIs) GetEnumValue() string {
+	return e.enum.Value(e.value)
+}
+
+func (e enumIs) String() string {
+	return e.enum.id + " == " + e.enum.Value(e.value)
+}
+
+type enumValue enumIs
+
+func (e enumValue) GetStateId() string {
+	return e.enum.id
+}
+
+func (e enumValue) Eval(v uint64) bool {
+	return enumIs(e).Eval(v)
+}
+
+func (m *machine[S]) enumById(id string) *Enum {
+	for _, e := range m.enums {
+		if e.id == id {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// enumByBits returns the enum whose field holds all the bits, nil for zero
+// bits or bits outside of a single field.
+func (m *Multistate) enumByBits(v uint64) *Enum {
+	for _, e := range m.enums {
+		if v != 0 && v&^e.mask == 0 {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// GetEnums returns the enum fields in the order they were added.
+func (m *machine[S]) GetEnums() []*Enum {
+	return append([]*Enum(nil), m.enums...)
+}
+
+func (m *machine[S]) enumBits() uint64 {
+	var mask uint64
+	for _, e := range m.enums {
+		mask |= e.mask
+	}
+
+	return mask
+}
+
+// checkEnums returns an error naming the first field of the state holding an
+// undeclared value.
+func (m *machine[S]) checkEnums(state S) error {
+	for _, e := range m.enums {
+		if low := m.ops.low(state); !e.valid(low) {
+			return fmt.Errorf("enum '%s' has undeclared value %d in state %v", e.id, (low&e.mask)>>e.shift, state)
+		}
+	}
+
+	return nil
+}
+
+func (m *machine[S]) enumFlags(state uint64) []StateFlag {
+	var res []StateFlag
+	for _, e := range m.enums {
+		if v := e.Value(state); v != "" {
+			res = append(res, StateFlag{Id: e.id + "=" + v, Bit: e.shift, Caption: e.id + "=" + v})
+		}
+	}
+
+	return res
+}
+
+// diffFlags returns the flags set and reset by the transition between the
+// states. A changed enum field is reported as its new value in the set list
+// and its previous value in the reset list.
+func (m *machine[S]) diffFlags(from, to S) ([]StateFlag, []StateFlag) {
+	enumBits := m.ops.fromLow(m.enumBits())
+	set := m.GetStateFlags(m.ops.andNot(m.ops.andNot(to, from), enumBits))
+	reset := m.GetStateFlags(m.ops.andNot(m.ops.andNot(from, to), enumBits))
+
+	lowFrom, lowTo := m.ops.low(from), m.ops.low(to)
+	for _, e := range m.enums {
+		if lowFrom&e.mask == lowTo&e.mask {
+			continue
+		}
+		if v := e.Value(lowTo); v != "" {
+			set = append(set, StateFlag{Id: e.id + "=" + v, Bit: e.shift, Caption: e.id + "=" + v})
+		}
+		if v := e.Value(lowFrom); v != "" {
+			reset = append(reset, StateFlag{Id: e.id + "=" + v, Bit: e.shift, Caption: e.id + "=" + v})
+		}
+	}
+
+	sort.Slice(set, func(i, j int) bool { return set[i].Bit < set[j].Bit })
+	sort.Slice(reset, func(i, j int) bool { return reset[i].Bit < reset[j].Bit })
+
+	return set, reset
+}
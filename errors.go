@@ -1,6 +1,9 @@
 package multistate
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	ErrInvalidState    = errors.New("invalid_state_error")
@@ -8,4 +11,80 @@ var (
 	ErrExecutionAction = errors.New("execute_action_error")
 	ErrSetState        = errors.New("set_state_error")
 	ErrNotAvailable    = errors.New("action_not_available_error")
+	ErrTooManyStates   = errors.New("too_many_states_error")
+	ErrLazyMode        = errors.New("unavailable_in_lazy_mode_error")
+	ErrStateConflict   = errors.New("state_conflict_error")
+	ErrNoPath          = errors.New("no_path_error")
+	ErrAutomaticLoop   = errors.New("automatic_loop_error")
+)
+
+type ActionPhase string
+
+const (
+	PhaseStart        ActionPhase = "start"
+	PhaseGetState     ActionPhase = "get"
+	PhaseValidate     ActionPhase = "validate"
+	PhaseAvailability ActionPhase = "availability"
+	PhaseMiddleware   ActionPhase = "middleware"
+	PhaseOnDo         ActionPhase = "onDo"
+	PhaseDo           ActionPhase = "do"
+	PhaseExit         ActionPhase = "exit"
+	PhaseEnter        ActionPhase = "enter"
+	PhaseSetState     ActionPhase = "set"
+	PhaseHistory      ActionPhase = "history"
+	PhaseEnd          ActionPhase = "end"
 )
+
+// ActionErrorOf is passed to EndAction of the entity and returned by
+// DoAction when an action fails, or when the history of a committed action
+// can't be written. FromState and ToState are set once they are known. It
+// unwraps to the cause, so errors.Is works with the sentinel errors above.
+// Compensation holds the errors of the compensations run for the failure.
+type ActionErrorOf[S StateValue] struct {
+	Action       string
+	EntityId     interface{}
+	FromState    S
+	ToState      S
+	Phase        ActionPhase
+	Cause        error
+	Compensation error
+}
+
+type ActionError = ActionErrorOf[uint64]
+
+func (e *ActionErrorOf[S]) Error() string {
+	if e.Compensation != nil {
+		return e.Cause.Error() + "; " + e.Compensation.Error()
+	}
+
+	return e.Cause.Error()
+}
+
+func (e *ActionErrorOf[S]) Unwrap() error {
+	return e.Cause
+}
+
+// UnavailableError lists the reasons an action is unavailable. It matches
+// ErrNotAvailable with errors.Is.
+type UnavailableError struct {
+	Reasons []Reason
+}
+
+func (e *UnavailableError) Error() string {
+	var messages []string
+	for _, r := range e.Reasons {
+		if r.Message != "" {
+			messages = append(messages, r.Message)
+		}
+	}
+
+	if len(messages) == 0 {
+		return ErrNotAvailable.Error()
+	}
+
+	return ErrNotAvailable.Error() + ": " + strings.Join(messages, "; ")
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrNotAvailable
+}
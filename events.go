@@ -0,0 +1,134 @@
+package multistate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransitionEventOf describes a transition that was written and whose
+// EndAction succeeded. SetFlags and ResetFlags are the flags that changed,
+// including the ones changed by automatic actions. A changed enum field is
+// reported as "id=new" in SetFlags and "id=old" in ResetFlags.
+type TransitionEventOf[S StateValue] struct {
+	EntityId   interface{}
+	Action     string
+	FromState  S
+	ToState    S
+	SetFlags   []StateFlag
+	ResetFlags []StateFlag
+	Time       time.Time
+}
+
+type TransitionEvent = TransitionEventOf[uint64]
+
+type TransitionHandlerOf[S StateValue] func(ctx context.Context, event TransitionEventOf[S])
+
+type TransitionHandler = TransitionHandlerOf[uint64]
+
+type eventBus[S StateValue] struct {
+	mu          sync.RWMutex
+	handlers    []TransitionHandlerOf[S]
+	subscribers map[*subscriber[S]]struct{}
+	dropped     atomic.Uint64
+}
+
+type subscriber[S StateValue] struct {
+	ch chan TransitionEventOf[S]
+}
+
+// OnTransitionCommitted registers a handler called synchronously by DoAction
+// after the transition is committed.
+func (m *machine[S]) OnTransitionCommitted(h TransitionHandlerOf[S]) {
+	m.events.mu.Lock()
+	defer m.events.mu.Unlock()
+
+	m.events.handlers = append(m.events.handlers, h)
+}
+
+// Subscribe returns a channel receiving the committed transitions and a
+// function to unsubscribe. The delivery is best-effort: DoAction never waits
+// for the subscribers, an event not fitting in the buffer is dropped and
+// counted by DroppedEvents. Use OnTransitionCommitted when every event must
+// be seen.
+func (m *machine[S]) Subscribe(buffer int) (<-chan TransitionEventOf[S], func(), error) {
+	if buffer <= 0 {
+		return nil, nil, fmt.Errorf("invalid buffer size %d, the events would always be dropped", buffer)
+	}
+
+	m.events.mu.Lock()
+	defer m.events.mu.Unlock()
+
+	sub := &subscriber[S]{
+		ch: make(chan TransitionEventOf[S], buffer),
+	}
+	if m.events.subscribers == nil {
+		m.events.subscribers = make(map[*subscriber[S]]struct{})
+	}
+	m.events.subscribers[sub] = struct{}{}
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			m.events.mu.Lock()
+			defer m.events.mu.Unlock()
+
+			delete(m.events.subscribers, sub)
+			close(sub.ch)
+		})
+	}, nil
+}
+
+func (m *machine[S]) MustSubscribe(buffer int) (<-chan TransitionEventOf[S], func()) {
+	ch, unsubscribe, err := m.Subscribe(buffer)
+	if err != nil {
+		panic(err)
+	}
+
+	return ch, unsubscribe
+}
+
+func (m *machine[S]) publishTransition(ctx context.Context, entityId interface{}, action string, fromState, toState S) {
+	m.events.mu.RLock()
+	handlers := m.events.handlers
+	hasSubscribers := len(m.events.subscribers) > 0
+	m.events.mu.RUnlock()
+
+	if len(handlers) == 0 && !hasSubscribers {
+		return
+	}
+
+	setFlags, resetFlags := m.diffFlags(fromState, toState)
+	event := TransitionEventOf[S]{
+		EntityId:   entityId,
+		Action:     action,
+		FromState:  fromState,
+		ToState:    toState,
+		SetFlags:   setFlags,
+		ResetFlags: resetFlags,
+		Time:       time.Now(),
+	}
+
+	for _, h := range handlers {
+		h(ctx, event)
+	}
+
+	m.events.mu.RLock()
+	defer m.events.mu.RUnlock()
+
+	for sub := range m.events.subscribers {
+		select {
+		case sub.ch <- event:
+		default:
+			m.events.dropped.Add(1)
+		}
+	}
+}
+
+// DroppedEvents returns how many events didn't fit in the buffers of the
+// subscribers.
+func (m *machine[S]) DroppedEvents() uint64 {
+	return m.events.dropped.Load()
+}
@@ -0,0 +1,67 @@
+package expr
+
+import "github.com/go-qbit/multistate/bitset"
+
+// BitsetExpression is implemented by the expressions able to evaluate states
+// of more than 64 flags.
+type BitsetExpression interface {
+	EvalBitset(v bitset.Bitset) bool
+}
+
+// EvalBitset evaluates the expression against the state. An expression not
+// implementing BitsetExpression sees only the first 64 flags.
+func EvalBitset(e Expression, v bitset.Bitset) bool {
+	if be, ok := e.(BitsetExpression); ok {
+		return be.EvalBitset(v)
+	}
+
+	low, _ := v.Uint64()
+
+	return e.Eval(low)
+}
+
+func (e andExpr) EvalBitset(v bitset.Bitset) bool {
+	for _, expr := range e {
+		if !EvalBitset(expr, v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e orExpr) EvalBitset(v bitset.Bitset) bool {
+	for _, expr := range e {
+		if EvalBitset(expr, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e xorExpr) EvalBitset(v bitset.Bitset) bool {
+	var c int
+	for _, expr := range e {
+		if EvalBitset(expr, v) {
+			c++
+		}
+		if c > 1 {
+			return false
+		}
+	}
+
+	return c == 1
+}
+
+func (e notExpr) EvalBitset(v bitset.Bitset) bool {
+	return !EvalBitset(e.e, v)
+}
+
+func (e exprAny) EvalBitset(bitset.Bitset) bool {
+	return true
+}
+
+func (e exprEmpty) EvalBitset(v bitset.Bitset) bool {
+	return v.IsZero()
+}
@@ -14,6 +14,10 @@ func And(e1, e2 Expression, eN ...Expression) andExpr {
 	return append(andExpr{e1, e2}, eN...)
 }
 
+func (e andExpr) String() string {
+	return formatList(e, " & ", precAnd)
+}
+
 func (e andExpr) Eval(v uint64) bool {
 	for _, expr := range e {
 		if !expr.Eval(v) {
@@ -34,6 +38,10 @@ func Or(e1, e2 Expression, eN ...Expression) orExpr {
 	return append(orExpr{e1, e2}, eN...)
 }
 
+func (e orExpr) String() string {
+	return formatList(e, " | ", precOr)
+}
+
 func (e orExpr) Eval(v uint64) bool {
 	for _, expr := range e {
 		if expr.Eval(v) {
@@ -53,6 +61,10 @@ func Xor(e1, e2 Expression, eN ...Expression) xorExpr {
 	return append(xorExpr{e1, e2}, eN...)
 }
 
+func (e xorExpr) String() string {
+	return formatList(e, " ^ ", precXor)
+}
+
 func (e xorExpr) Eval(v uint64) bool {
 	var c int
 	for _, expr := range e {
@@ -74,6 +86,10 @@ func Not(e Expression) notExpr {
 	return notExpr{e}
 }
 
+func (e notExpr) String() string {
+	return "!" + format(e.e, precNot)
+}
+
 func (e notExpr) Eval(v uint64) bool {
 	return !e.e.Eval(v)
 }
@@ -84,6 +100,10 @@ func Any() exprAny {
 	return exprAny{}
 }
 
+func (e exprAny) String() string {
+	return "any"
+}
+
 func (e exprAny) Eval(uint64) bool {
 	return true
 }
@@ -94,6 +114,10 @@ func Empty() exprEmpty {
 	return exprEmpty{}
 }
 
+func (e exprEmpty) String() string {
+	return "empty"
+}
+
 func (e exprEmpty) Eval(v uint64) bool {
 	return v == 0
 }
@@ -0,0 +1,188 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	OpAnd   = "and"
+	OpOr    = "or"
+	OpXor   = "xor"
+	OpNot   = "not"
+	OpAny   = "any"
+	OpEmpty = "empty"
+	OpState = "state"
+	OpEnum  = "enum"
+)
+
+// Node is a serializable representation of an expression tree.
+type Node struct {
+	Op    string `json:"op"`
+	State string `json:"state,omitempty"`
+	Value string `json:"value,omitempty"`
+	Args  []Node `json:"args,omitempty"`
+}
+
+// Resolver returns the expression registered for a state id.
+type Resolver func(id string) (Expression, error)
+
+// EnumField is implemented by the identifiers standing for an enum field,
+// EnumValue builds the comparison of the field with the value.
+type EnumField interface {
+	EnumValue(value string) (Expression, error)
+}
+
+// EnumComparison is implemented by the expressions comparing an enum field
+// with a value.
+type EnumComparison interface {
+	GetEnumId() string
+	GetEnumValue() string
+}
+
+type identifier interface {
+	GetStateId() string
+}
+
+type nodeIdent string
+
+func (i nodeIdent) GetStateId() string { return string(i) }
+func (i nodeIdent) Eval(uint64) bool   { return false }
+
+func (i nodeIdent) EnumValue(value string) (Expression, error) {
+	return nodeEnum{string(i), value}, nil
+}
+
+type nodeEnum struct {
+	id, value string
+}
+
+func (e nodeEnum) GetEnumId() string    { return e.id }
+func (e nodeEnum) GetEnumValue() string { return e.value }
+func (e nodeEnum) Eval(uint64) bool     { return false }
+
+// ParseNode parses the textual form of an expression without resolving its
+// identifiers.
+func ParseNode(s string) (Node, error) {
+	e, err := Parse(s, func(id string) (Expression, error) {
+		return nodeIdent(id), nil
+	})
+	if err != nil {
+		return Node{}, err
+	}
+
+	return ToNode(e)
+}
+
+// UnmarshalJSON accepts both the object form of a node and the textual form
+// of an expression. An empty text is rejected, null leaves the node unset.
+func (n *Node) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		if strings.TrimSpace(text) == "" {
+			return errors.New("empty expression")
+		}
+		parsed, err := ParseNode(text)
+		if err != nil {
+			return err
+		}
+		*n = parsed
+		return nil
+	}
+
+	type plainNode Node
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*plainNode)(n))
+}
+
+func ToNode(e Expression) (Node, error) {
+	var op string
+	var args []Expression
+
+	switch e := e.(type) {
+	case andExpr:
+		op, args = OpAnd, e
+	case orExpr:
+		op, args = OpOr, e
+	case xorExpr:
+		op, args = OpXor, e
+	case notExpr:
+		op, args = OpNot, []Expression{e.e}
+	case exprAny:
+		return Node{Op: OpAny}, nil
+	case exprEmpty:
+		return Node{Op: OpEmpty}, nil
+	case EnumComparison:
+		return Node{Op: OpEnum, State: e.GetEnumId(), Value: e.GetEnumValue()}, nil
+	case identifier:
+		return Node{Op: OpState, State: e.GetStateId()}, nil
+	default:
+		return Node{}, fmt.Errorf("unsupported expression type %T", e)
+	}
+
+	n := Node{Op: op, Args: make([]Node, len(args))}
+	for i, arg := range args {
+		var err error
+		if n.Args[i], err = ToNode(arg); err != nil {
+			return Node{}, err
+		}
+	}
+
+	return n, nil
+}
+
+func (n Node) Expression(resolve Resolver) (Expression, error) {
+	args := make([]Expression, len(n.Args))
+	for i, arg := range n.Args {
+		var err error
+		if args[i], err = arg.Expression(resolve); err != nil {
+			return nil, err
+		}
+	}
+
+	switch n.Op {
+	case OpAnd, OpOr, OpXor:
+		if len(args) < 2 {
+			return nil, fmt.Errorf("operation '%s' requires at least 2 arguments", n.Op)
+		}
+		switch n.Op {
+		case OpAnd:
+			return And(args[0], args[1], args[2:]...), nil
+		case OpOr:
+			return Or(args[0], args[1], args[2:]...), nil
+		default:
+			return Xor(args[0], args[1], args[2:]...), nil
+		}
+	case OpNot:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("operation '%s' requires exactly 1 argument", n.Op)
+		}
+		return Not(args[0]), nil
+	case OpAny:
+		return Any(), nil
+	case OpEmpty:
+		return Empty(), nil
+	case OpState:
+		return resolve(n.State)
+	case OpEnum:
+		e, err := resolve(n.State)
+		if err != nil {
+			return nil, err
+		}
+		field, ok := e.(EnumField)
+		if !ok {
+			return nil, fmt.Errorf("'%s' isn't an enum", n.State)
+		}
+		return field.EnumValue(n.Value)
+	}
+
+	return nil, fmt.Errorf("unknown operation '%s'", n.Op)
+}
@@ -0,0 +1,289 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operator precedence, from the loosest to the tightest binding.
+const (
+	precOr = iota + 1
+	precXor
+	precAnd
+	precNot
+	precAtom
+)
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokAnd
+	tokOr
+	tokXor
+	tokNot
+	tokLParen
+	tokRParen
+	tokEq
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+var punctuation = map[byte]int{'&': tokAnd, '|': tokOr, '^': tokXor, '!': tokNot, '(': tokLParen, ')': tokRParen}
+
+type parser struct {
+	src     string
+	pos     int
+	tok     token
+	resolve Resolver
+}
+
+// Parse builds an expression from its textual form, e.g.
+// "(signed_a | signed_b) & !signed_c". The operators are ! (not), & (and),
+// ^ (xor, exactly one of) and | (or), listed by decreasing precedence. The
+// keywords "any" and "empty" stand for Any() and Empty(), every other
+// identifier is passed to the resolver. "risk == high" compares an enum
+// field, the identifier must resolve to an EnumField; the comparison binds
+// tighter than any operator.
+func Parse(s string, resolve Resolver) (Expression, error) {
+	p := &parser{src: s, resolve: resolve}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	e, err := p.parseBinary(precOr)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+
+	return e, nil
+}
+
+func MustParse(s string, resolve Resolver) Expression {
+	e, err := Parse(s, resolve)
+	if err != nil {
+		panic(err)
+	}
+
+	return e
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("expression '%s', position %d: "+format, append([]interface{}{p.src, p.tok.pos + 1}, args...)...)
+}
+
+func (p *parser) next() error {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+
+	start := p.pos
+	if p.pos == len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+
+	if strings.HasPrefix(p.src[p.pos:], "==") {
+		p.pos += 2
+		p.tok = token{kind: tokEq, text: "==", pos: start}
+		return nil
+	}
+
+	if kind, exists := punctuation[p.src[p.pos]]; exists {
+		p.pos++
+		p.tok = token{kind: kind, text: p.src[start:p.pos], pos: start}
+		return nil
+	}
+
+	for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		p.tok = token{pos: start}
+		return p.errorf("unexpected character '%c'", p.src[start])
+	}
+
+	p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+
+	return nil
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+var binaryOps = map[int]struct {
+	tok  int
+	join func(e1, e2 Expression, eN ...Expression) Expression
+}{
+	precOr:  {tokOr, func(e1, e2 Expression, eN ...Expression) Expression { return Or(e1, e2, eN...) }},
+	precXor: {tokXor, func(e1, e2 Expression, eN ...Expression) Expression { return Xor(e1, e2, eN...) }},
+	precAnd: {tokAnd, func(e1, e2 Expression, eN ...Expression) Expression { return And(e1, e2, eN...) }},
+}
+
+func (p *parser) parseBinary(prec int) (Expression, error) {
+	if prec == precNot {
+		return p.parseUnary()
+	}
+
+	op := binaryOps[prec]
+
+	var operands []Expression
+	for {
+		e, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, e)
+
+		if p.tok.kind != op.tok {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+
+	return op.join(operands[0], operands[1], operands[2:]...), nil
+}
+
+func (p *parser) parseUnary() (Expression, error) {
+	switch p.tok.kind {
+	case tokNot:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(e), nil
+
+	case tokLParen:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseBinary(precOr)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected ')', got %s", p.tok)
+		}
+		return e, p.next()
+
+	case tokIdent:
+		tok := p.tok
+		var e Expression
+		switch tok.text {
+		case "any":
+			e = Any()
+		case "empty":
+			e = Empty()
+		default:
+			var err error
+			if e, err = p.resolve(tok.text); err != nil {
+				return nil, p.errorf("%w", err)
+			}
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokEq {
+			return e, nil
+		}
+
+		field, ok := e.(EnumField)
+		if !ok {
+			return nil, p.errorf("'%s' isn't an enum", tok.text)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokIdent {
+			return nil, p.errorf("expected enum value, got %s", p.tok)
+		}
+		var err error
+		if e, err = field.EnumValue(p.tok.text); err != nil {
+			return nil, p.errorf("%w", err)
+		}
+		return e, p.next()
+	}
+
+	return nil, p.errorf("unexpected %s", p.tok)
+}
+
+// String formats any expression in the syntax accepted by Parse.
+func String(e Expression) string {
+	return format(e, 0)
+}
+
+func precedence(e Expression) int {
+	switch e.(type) {
+	case andExpr:
+		return precAnd
+	case orExpr:
+		return precOr
+	case xorExpr:
+		return precXor
+	case notExpr:
+		return precNot
+	}
+
+	return precAtom
+}
+
+func format(e Expression, parentPrec int) string {
+	var s string
+	switch e := e.(type) {
+	case EnumComparison:
+		s = e.GetEnumId() + " == " + e.GetEnumValue()
+	case identifier:
+		s = e.GetStateId()
+	case fmt.Stringer:
+		s = e.String()
+	default:
+		s = fmt.Sprintf("%v", e)
+	}
+
+	if precedence(e) < parentPrec {
+		return "(" + s + ")"
+	}
+
+	return s
+}
+
+func formatList(list []Expression, sep string, prec int) string {
+	// Xor means "exactly one of", so a nested xor is not associative and
+	// must keep its parentheses.
+	childPrec := prec
+	if prec == precXor {
+		childPrec++
+	}
+
+	parts := make([]string, len(list))
+	for i, e := range list {
+		parts[i] = format(e, childPrec)
+	}
+
+	return strings.Join(parts, sep)
+}
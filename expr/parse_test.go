@@ -0,0 +1,150 @@
+package expr_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/go-qbit/multistate/expr"
+)
+
+type testState struct {
+	id  string
+	bit uint8
+}
+
+func (s testState) GetStateId() string { return s.id }
+func (s testState) Eval(v uint64) bool { return v&(1<<s.bit) > 0 }
+
+var (
+	stA = testState{"signed_a", 0}
+	stB = testState{"signed_b", 1}
+	stC = testState{"signed_c", 2}
+)
+
+// testEnum is a field of 2 bits starting from bit 4.
+type testEnum struct{}
+
+func (testEnum) GetStateId() string { return "risk" }
+func (testEnum) Eval(v uint64) bool { return v&0x30 != 0 }
+
+func (testEnum) EnumValue(value string) (Expression, error) {
+	for i, v := range []string{"low", "high"} {
+		if v == value {
+			return testEnumIs{value, uint64(i+1) << 4}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown risk '%s'", value)
+}
+
+type testEnumIs struct {
+	value string
+	bits  uint64
+}
+
+func (e testEnumIs) GetEnumId() string    { return "risk" }
+func (e testEnumIs) GetEnumValue() string { return e.value }
+func (e testEnumIs) Eval(v uint64) bool   { return v&0x30 == e.bits }
+
+func testResolver(id string) (Expression, error) {
+	for _, s := range []testState{stA, stB, stC} {
+		if s.id == id {
+			return s, nil
+		}
+	}
+	if id == "risk" {
+		return testEnum{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown state '%s'", id)
+}
+
+func TestParse(t *testing.T) {
+	for text, expected := range map[string]Expression{
+		"signed_a":                             stA,
+		"any":                                  Any(),
+		"empty":                                Empty(),
+		"(signed_a | signed_b) & !signed_c":    And(Or(stA, stB), Not(stC)),
+		"signed_a | signed_b & signed_c":       Or(stA, And(stB, stC)),
+		"signed_a ^ signed_b ^ signed_c":       Xor(stA, stB, stC),
+		"(signed_a ^ signed_b) ^ signed_c":     Xor(Xor(stA, stB), stC),
+		"signed_a | signed_b ^ signed_c":       Or(stA, Xor(stB, stC)),
+		"!!signed_a":                           Not(Not(stA)),
+		" !( signed_a&signed_b )|empty ":       Or(Not(And(stA, stB)), Empty()),
+		"signed_a & signed_b & signed_c | any": Or(And(stA, stB, stC), Any()),
+		"risk==high & !risk == low":            And(testEnumIs{"high", 0x20}, Not(testEnumIs{"low", 0x10})),
+		"risk | signed_a":                      Or(testEnum{}, stA),
+	} {
+		e, err := Parse(text, testResolver)
+		require.NoError(t, err, text)
+		assert.Equal(t, expected, e, text)
+
+		again, err := Parse(String(e), testResolver)
+		require.NoError(t, err, text)
+		assert.Equal(t, e, again, text)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for text, msg := range map[string]string{
+		"":                     "expression '', position 1: unexpected end of expression",
+		"signed_a &":           "expression 'signed_a &', position 11: unexpected end of expression",
+		"(signed_a":            "expression '(signed_a', position 10: expected ')', got end of expression",
+		"signed_a signed_b":    "expression 'signed_a signed_b', position 10: unexpected 'signed_b'",
+		"signed_a + signed_b":  "expression 'signed_a + signed_b', position 10: unexpected character '+'",
+		"signed_a & signed_x":  "expression 'signed_a & signed_x', position 12: unknown state 'signed_x'",
+		"signed_a & )signed_b": "expression 'signed_a & )signed_b', position 12: unexpected ')'",
+		"signed_a == low":      "expression 'signed_a == low', position 10: 'signed_a' isn't an enum",
+		"risk == medium":       "expression 'risk == medium', position 9: unknown risk 'medium'",
+		"risk == (low)":        "expression 'risk == (low)', position 9: expected enum value, got '('",
+		"risk = low":           "expression 'risk = low', position 6: unexpected character '='",
+	} {
+		_, err := Parse(text, testResolver)
+		assert.EqualError(t, err, msg, text)
+	}
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "(signed_a | signed_b) & !signed_c", String(And(Or(stA, stB), Not(stC))))
+	assert.Equal(t, "!(signed_a & signed_b)", Not(And(stA, stB)).String())
+	assert.Equal(t, "(signed_a ^ signed_b) ^ signed_c", Xor(Xor(stA, stB), stC).String())
+	assert.Equal(t, "signed_a & signed_b & signed_c", And(And(stA, stB), stC).String())
+	assert.Equal(t, "empty | any", Or(Empty(), Any()).String())
+}
+
+func TestNode_Enum(t *testing.T) {
+	n, err := ParseNode("signed_a & risk == high")
+	require.NoError(t, err)
+	assert.Equal(t, Node{Op: OpAnd, Args: []Node{
+		{Op: OpState, State: "signed_a"},
+		{Op: OpEnum, State: "risk", Value: "high"},
+	}}, n)
+
+	e, err := n.Expression(testResolver)
+	require.NoError(t, err)
+	assert.Equal(t, And(stA, testEnumIs{"high", 0x20}), e)
+	assert.Equal(t, "signed_a & risk == high", String(e))
+
+	_, err = Node{Op: OpEnum, State: "signed_a", Value: "high"}.Expression(testResolver)
+	assert.EqualError(t, err, "'signed_a' isn't an enum")
+}
+
+func TestNode_UnmarshalJSON(t *testing.T) {
+	var n Node
+	require.NoError(t, json.Unmarshal([]byte(`"signed_a & !signed_b"`), &n))
+	assert.Equal(t, Node{Op: OpAnd, Args: []Node{
+		{Op: OpState, State: "signed_a"},
+		{Op: OpNot, Args: []Node{{Op: OpState, State: "signed_b"}}},
+	}}, n)
+
+	n = Node{}
+	require.NoError(t, json.Unmarshal([]byte(`null`), &n))
+	assert.Equal(t, Node{}, n)
+
+	assert.EqualError(t, json.Unmarshal([]byte(`" "`), &n), "empty expression")
+	assert.EqualError(t, json.Unmarshal([]byte(`{"op": "state", "id": "signed_a"}`), &n), `json: unknown field "id"`)
+}
@@ -0,0 +1,79 @@
+package multistate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// StateChangeFuncOf is called when an action turns a state flag on or off.
+type StateChangeFuncOf[S StateValue] func(ctx context.Context, entity EntityOf[S], prevState, newState S, action string) error
+
+type StateChangeFunc = StateChangeFuncOf[uint64]
+
+// OnEnter registers a callback run by DoAction, after the state is written
+// and before EndAction, whenever an action sets the flag that wasn't set
+// before. An error of the callback fails the action, so EndAction can roll
+// the state back.
+func (m *machine[S]) OnEnter(s State, fn StateChangeFuncOf[S]) error {
+	st, exists := m.statesMap[s.GetStateId()]
+	if !exists {
+		return fmt.Errorf("state '%s' doesn't exists", s.GetStateId())
+	}
+
+	if m.onEnter == nil {
+		m.onEnter = make(map[uint8][]StateChangeFuncOf[S])
+	}
+	m.onEnter[st.bit] = append(m.onEnter[st.bit], fn)
+
+	return nil
+}
+
+// OnExit registers a callback run like the OnEnter ones whenever an action
+// resets the flag that was set before. Exit callbacks run before enter ones.
+func (m *machine[S]) OnExit(s State, fn StateChangeFuncOf[S]) error {
+	st, exists := m.statesMap[s.GetStateId()]
+	if !exists {
+		return fmt.Errorf("state '%s' doesn't exists", s.GetStateId())
+	}
+
+	if m.onExit == nil {
+		m.onExit = make(map[uint8][]StateChangeFuncOf[S])
+	}
+	m.onExit[st.bit] = append(m.onExit[st.bit], fn)
+
+	return nil
+}
+
+func (m *machine[S]) runFlagHooks(ctx context.Context, entity EntityOf[S], prevState, newState S, action string) (ActionPhase, error) {
+	for _, h := range []struct {
+		phase ActionPhase
+		hooks map[uint8][]StateChangeFuncOf[S]
+		bits  S
+	}{
+		{PhaseExit, m.onExit, m.ops.andNot(prevState, newState)},
+		{PhaseEnter, m.onEnter, m.ops.andNot(newState, prevState)},
+	} {
+		if m.ops.isZero(h.bits) || len(h.hooks) == 0 {
+			continue
+		}
+
+		bits := make([]uint8, 0, len(h.hooks))
+		for bit := range h.hooks {
+			if m.ops.has(h.bits, bit) {
+				bits = append(bits, bit)
+			}
+		}
+		sort.Slice(bits, func(i, j int) bool { return bits[i] < bits[j] })
+
+		for _, bit := range bits {
+			for _, fn := range h.hooks[bit] {
+				if err := fn(ctx, entity, prevState, newState, action); err != nil {
+					return h.phase, fmt.Errorf("%w: %w", ErrExecutionAction, err)
+				}
+			}
+		}
+	}
+
+	return "", nil
+}
@@ -4,24 +4,31 @@ import (
 	"context"
 	"fmt"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/go-qbit/multistate/bitset"
 	"github.com/go-qbit/multistate/expr"
+	"github.com/go-qbit/multistate/internal/naming"
 )
 
 type Implementation interface{}
 
-type Action struct {
+type ActionOf[S StateValue] struct {
 	Caption    string
 	From       expr.Expression
 	Set        States
 	Reset      States
-	OnDo       ActionDoFunc
+	OnDo       ActionDoFuncOf[S]
 	Availabler Availabler
+	Automatic  bool
+	Compensate ActionDoFuncOf[S]
 }
 
+type Action = ActionOf[uint64]
+
+type BitsetAction = ActionOf[bitset.Bitset]
+
 type Cluster struct {
 	Caption string
 	Expr    expr.Expression
@@ -29,6 +36,36 @@ type Cluster struct {
 
 func NewFromStructWithEmptyName(s Implementation, emptyStateName string) *Multistate {
 	mst := New(emptyStateName)
+	addFromStruct(&mst.machine, s, "multistate.Action")
+	mst.MustCompile()
+
+	return mst
+}
+
+func NewFromStruct(s Implementation) *Multistate {
+	return NewFromStructWithEmptyName(s, "New")
+}
+
+// NewBitsetFromStructWithEmptyName is NewFromStructWithEmptyName for a
+// BitsetMultistate, whose action methods return BitsetAction. The multistate
+// isn't compiled, so its actions are evaluated on demand until Compile is
+// called.
+func NewBitsetFromStructWithEmptyName(s Implementation, emptyStateName string) *BitsetMultistate {
+	mst := NewBitset(emptyStateName)
+	addFromStruct(&mst.machine, s, "multistate.BitsetAction")
+
+	return mst
+}
+
+func NewBitsetFromStruct(s Implementation) *BitsetMultistate {
+	return NewBitsetFromStructWithEmptyName(s, "New")
+}
+
+// addFromStruct adds the states of the State fields of the structure and the
+// actions, callback and clusters returned by its methods.
+func addFromStruct[S StateValue](mst *machine[S], s Implementation, actionName string) {
+	stateType := reflect.TypeOf((*State)(nil)).Elem()
+	actionType := reflect.TypeOf(ActionOf[S]{})
 
 	rtS := reflect.TypeOf(s)
 	rvS := reflect.ValueOf(s)
@@ -41,7 +78,7 @@ func NewFromStructWithEmptyName(s Implementation, emptyStateName string) *Multis
 	}
 	for i := 0; i < rvStruct.NumField(); i++ {
 		ft := rtStruct.Field(i)
-		if ft.Type.String() != "multistate.State" {
+		if ft.Type != stateType {
 			continue
 		}
 
@@ -49,7 +86,7 @@ func NewFromStructWithEmptyName(s Implementation, emptyStateName string) *Multis
 		if !exists {
 			panic(fmt.Sprintf("Missed required tag 'bit' for field '%s'", ft.Name))
 		}
-		bit, err := strconv.ParseUint(strBit, 10, 7)
+		bit, err := strconv.ParseUint(strBit, 10, 8)
 		if err != nil {
 			panic(fmt.Sprintf("Invalid 'bit' value for field '%s'", ft.Name))
 		}
@@ -59,7 +96,7 @@ func NewFromStructWithEmptyName(s Implementation, emptyStateName string) *Multis
 			caption = t
 		}
 
-		rvStruct.Field(i).Set(reflect.ValueOf(mst.MustAddState(uint8(bit), camelCaseToSnake(ft.Name), caption)))
+		rvStruct.Field(i).Set(reflect.ValueOf(mst.MustAddState(uint8(bit), naming.CamelCaseToSnake(ft.Name), caption)))
 	}
 
 	for i := 0; i < rtS.NumMethod(); i++ {
@@ -67,11 +104,11 @@ func NewFromStructWithEmptyName(s Implementation, emptyStateName string) *Multis
 
 		if strings.HasPrefix(mt.Name, "Action") {
 			values := rvS.Method(i).Call(nil)
-			if len(values) != 1 || values[0].Type().String() != "multistate.Action" {
-				panic(fmt.Sprintf("The action method %s must return the multistate.Action structure", mt.Name))
+			if len(values) != 1 || values[0].Type() != actionType {
+				panic(fmt.Sprintf("The action method %s must return the %s structure", mt.Name, actionName))
 			}
 
-			action := values[0].Interface().(Action)
+			action := values[0].Interface().(ActionOf[S])
 			caption := mt.Name[6:]
 			if action.Caption != "" {
 				caption = action.Caption
@@ -81,9 +118,16 @@ func NewFromStructWithEmptyName(s Implementation, emptyStateName string) *Multis
 				action.From = expr.Empty()
 			}
 
-			mst.MustAddAction(camelCaseToSnake(mt.Name[6:]), caption, action.From, action.Set, action.Reset, action.OnDo, action.Availabler)
+			id := naming.CamelCaseToSnake(mt.Name[6:])
+			mst.MustAddAction(id, caption, action.From, action.Set, action.Reset, action.OnDo, action.Availabler)
+			if action.Compensate != nil {
+				mst.MustSetCompensation(id, action.Compensate)
+			}
+			if action.Automatic {
+				mst.MustSetAutomatic(id)
+			}
 		} else if mt.Name == "OnDoAction" {
-			cb, ok := rvS.Method(i).Interface().(func(context.Context, Entity, uint64, uint64, string, ...interface{}) error)
+			cb, ok := rvS.Method(i).Interface().(func(context.Context, EntityOf[S], S, S, string, ...interface{}) error)
 			if !ok {
 				panic(fmt.Sprintf("OnDoAction must fit OnDoCallback type "))
 			}
@@ -99,21 +143,4 @@ func NewFromStructWithEmptyName(s Implementation, emptyStateName string) *Multis
 			}
 		}
 	}
-
-	mst.MustCompile()
-
-	return mst
-}
-
-func NewFromStruct(s Implementation) *Multistate {
-	return NewFromStructWithEmptyName(s, "New")
-}
-
-var matchFirstCap = regexp.MustCompile("(.)([A-Z][a-z]+)")
-var matchAllCap = regexp.MustCompile("([a-z0-9])([A-Z])")
-
-func camelCaseToSnake(s string) string {
-	snake := matchFirstCap.ReplaceAllString(s, "${1}_${2}")
-	snake = matchAllCap.ReplaceAllString(snake, "${1}_${2}")
-	return strings.ToLower(snake)
 }
@@ -5,19 +5,82 @@ import (
 	"crypto/md5"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"os/exec"
 	"runtime"
-	"strconv"
+	"strings"
 
 	"github.com/tmc/dot"
 )
 
-func (m *Multistate) GetGraphSVG() string {
+// GetGraphSVG renders the compiled graph with Graphviz when it is installed
+// and falls back to the built-in renderer otherwise.
+func (m *machine[S]) GetGraphSVG() string {
+	outBuf := &bytes.Buffer{}
+	if err := m.WriteGraphvizSVG(outBuf); err != nil {
+		outBuf.Reset()
+		_ = m.WriteSVG(outBuf)
+	}
+
+	return outBuf.String()
+}
+
+// WriteDOT writes the compiled graph in the Graphviz DOT language.
+func (m *machine[S]) WriteDOT(w io.Writer) error {
+	if m.IsLazy() {
+		return ErrLazyMode
+	}
+
+	_, err := io.WriteString(w, m.dotGraph().String())
+	return err
+}
+
+// WriteGraphvizSVG renders the graph by piping its DOT representation through
+// the external Graphviz dot binary.
+func (m *machine[S]) WriteGraphvizSVG(w io.Writer) error {
+	if m.IsLazy() {
+		return ErrLazyMode
+	}
+
+	pathToDot, err := lookupDot()
+	if err != nil {
+		return err
+	}
+
+	errBuf := &bytes.Buffer{}
+	cmd := exec.Command(pathToDot, "-Tsvg")
+	cmd.Stdin = strings.NewReader(m.dotGraph().String())
+	cmd.Stdout = w
+	cmd.Stderr = errBuf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("graphviz: %w: %s", err, strings.TrimSpace(errBuf.String()))
+	}
+
+	return nil
+}
+
+func lookupDot() (string, error) {
+	if path, err := exec.LookPath("dot"); err == nil {
+		return path, nil
+	}
+
+	pathToDot := "/usr/bin/dot"
+	if runtime.GOOS == "darwin" {
+		pathToDot = "/usr/local/bin/dot"
+	}
+	if _, err := os.Stat(pathToDot); err != nil {
+		return "", fmt.Errorf("graphviz dot binary not found: %w", err)
+	}
+
+	return pathToDot, nil
+}
+
+func (m *machine[S]) dotGraph() *dot.Graph {
 	g := dot.NewGraph("Multistate")
 
-	nodes := map[uint64]*dot.Node{}
+	nodes := map[S]*dot.Node{}
 	clusters := map[uint8]*dot.SubGraph{}
 
 	for _, cluster := range m.clusters {
@@ -28,7 +91,7 @@ func (m *Multistate) GetGraphSVG() string {
 		g.AddSubgraph(c)
 	}
 
-	for state := range m.statesActions {
+	for _, state := range m.sortedStates() {
 		var strFlags string
 		for i, flag := range m.GetStateFlags(state) {
 			if i > 0 {
@@ -43,20 +106,12 @@ func (m *Multistate) GetGraphSVG() string {
 			strFlags = "EMPTY"
 		}
 
-		hs := md5.New()
-		_ = binary.Write(hs, binary.LittleEndian, state)
-		digestBuf := bytes.NewBuffer(hs.Sum(nil))
-		var c1, c2 uint32
-		_ = binary.Read(digestBuf, binary.LittleEndian, &c1)
-		_ = binary.Read(digestBuf, binary.LittleEndian, &c2)
+		h, s, v := stateColor(m.ops.binary(state))
+		color := fmt.Sprintf("%f %f %f", h, s, v)
 
-		color := fmt.Sprintf("%f %f %f", float64(c1)/float64(math.MaxUint32),
-			float64(c2)/float64(math.MaxUint32),
-			0.7)
-
-		n := dot.NewNode(strconv.FormatUint(state, 16))
+		n := dot.NewNode(m.ops.nodeId(state))
 		_ = n.Set("shape", "plaintext")
-		_ = n.Set("label", fmt.Sprintf(`<<TABLE BORDER="0" CELLBORDER="1" CELLSPACING="0"><TR><TD><B>%d</B></TD><TD>%s</TD></TR></TABLE>>`, state, strFlags))
+		_ = n.Set("label", fmt.Sprintf(`<<TABLE BORDER="0" CELLBORDER="1" CELLSPACING="0"><TR><TD><B>%v</B></TD><TD>%s</TD></TR></TABLE>>`, state, strFlags))
 		_ = n.Set("color", color)
 		_ = n.Set("fontcolor", color)
 
@@ -68,34 +123,44 @@ func (m *Multistate) GetGraphSVG() string {
 		nodes[state] = n
 	}
 
-	for from, actions := range m.statesActions {
-		for action, to := range actions {
-			e := dot.NewEdge(nodes[from], nodes[to])
-			if m.actionsMap[action].availabler != nil {
-				_ = e.Set("label", fmt.Sprintf("%s\n(%s[%s])", m.actionsMap[action].caption, action, m.actionsMap[action].availabler.String()))
-			} else {
-				_ = e.Set("label", fmt.Sprintf("%s\n(%s)", m.actionsMap[action].caption, action))
-			}
-			color := nodes[from].Get("color")
-			_ = e.Set("color", color)
-			_ = e.Set("fontcolor", color)
-			g.AddEdge(e)
-		}
+	for _, c := range m.GetConnections() {
+		e := dot.NewEdge(nodes[c.From], nodes[c.To])
+		_ = e.Set("label", m.edgeLabel(c.Action))
+		color := nodes[c.From].Get("color")
+		_ = e.Set("color", color)
+		_ = e.Set("fontcolor", color)
+		g.AddEdge(e)
 	}
 
-	outBuf := &bytes.Buffer{}
+	return g
+}
 
-	pathToDot := "/usr/bin/dot"
-	if runtime.GOOS == "darwin" {
-		pathToDot = "/usr/local/bin/dot"
+func (m *machine[S]) sortedStates() []S {
+	res := make([]S, 0, len(m.statesActions))
+	for state := range m.statesActions {
+		res = append(res, state)
 	}
-	cmd := exec.Command(pathToDot, "-Tsvg")
-	cmd.Stdin = bytes.NewBuffer([]byte(g.String()))
-	cmd.Stdout = outBuf
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		panic(err)
+	m.sortStates(res)
+
+	return res
+}
+
+func (m *machine[S]) edgeLabel(action string) string {
+	if m.actionsMap[action].availabler != nil {
+		return fmt.Sprintf("%s\n(%s[%s])", m.actionsMap[action].caption, action, m.actionsMap[action].availabler.String())
 	}
 
-	return outBuf.String()
+	return fmt.Sprintf("%s\n(%s)", m.actionsMap[action].caption, action)
+}
+
+// stateColor derives a stable HSV color from the binary state value.
+func stateColor(state []byte) (h, s, v float64) {
+	hs := md5.New()
+	_, _ = hs.Write(state)
+	digestBuf := bytes.NewBuffer(hs.Sum(nil))
+	var c1, c2 uint32
+	_ = binary.Read(digestBuf, binary.LittleEndian, &c1)
+	_ = binary.Read(digestBuf, binary.LittleEndian, &c2)
+
+	return float64(c1) / float64(math.MaxUint32), float64(c2) / float64(math.MaxUint32), 0.7
 }
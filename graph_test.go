@@ -0,0 +1,118 @@
+package multistate_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-qbit/multistate"
+	. "github.com/go-qbit/multistate/expr"
+)
+
+func newGraphTestMultistate() *multistate.Multistate {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B & <co>")
+	signedC := mst.MustAddState(2, "signed_c", "Signed C")
+
+	mst.MustAddAction("sign_a", "Sign A", Empty(), multistate.States{signedA}, nil, nil, nil)
+	mst.MustAddAction("sign_b", "Sign B", Not(signedB), multistate.States{signedB}, nil, nil, nil)
+	mst.MustAddAction("sign_c", "Sign C", And(signedA, signedB), multistate.States{signedC}, multistate.States{signedA}, nil, nil)
+	mst.MustAddAction("revoke", "Revoke", signedC, nil, multistate.States{signedB, signedC}, nil, nil)
+
+	mst.AddCluster("Cluster B", signedB)
+
+	mst.MustCompile()
+
+	return mst
+}
+
+func TestMultistate_WriteDOT(t *testing.T) {
+	mst := newGraphTestMultistate()
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, mst.WriteDOT(buf))
+
+	assert.True(t, strings.HasPrefix(buf.String(), "digraph Multistate {"))
+	assert.Contains(t, buf.String(), "cluster_0")
+	assert.Contains(t, buf.String(), "(sign_c)")
+}
+
+func TestMultistate_WriteSVG(t *testing.T) {
+	mst := newGraphTestMultistate()
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, mst.WriteSVG(buf))
+
+	dec := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	for _, state := range []string{"state-0", "state-1", "state-2", "state-3", "state-6"} {
+		assert.Contains(t, buf.String(), `id="`+state+`"`)
+	}
+	assert.Contains(t, buf.String(), "Signed B &amp; &lt;co&gt;")
+	assert.Contains(t, buf.String(), "Cluster B")
+
+	again := &bytes.Buffer{}
+	require.NoError(t, mst.WriteSVG(again))
+	assert.Equal(t, buf.String(), again.String())
+}
+
+func TestMultistate_WriteMermaid(t *testing.T) {
+	mst := newGraphTestMultistate()
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, mst.WriteMermaid(buf))
+
+	assert.Equal(t, `stateDiagram-v2
+    state "New" as s0
+    state "Signed A." as s1
+    state "Cluster B" as cluster_0 {
+        state "Signed B & <co>." as s2
+        state "Signed A.<br/>Signed B & <co>." as s3
+        state "Signed B & <co>.<br/>Signed C." as s6
+    }
+    [*] --> s0
+    s0 --> s1 : Sign A
+    s0 --> s2 : Sign B
+    s1 --> s3 : Sign B
+    s3 --> s6 : Sign C
+    s6 --> s0 : Revoke
+`, buf.String())
+}
+
+func TestMultistate_WritePlantUML(t *testing.T) {
+	mst := newGraphTestMultistate()
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, mst.WritePlantUML(buf))
+
+	assert.Equal(t, `@startuml
+    state "New" as s0
+    state "Signed A." as s1
+    state "Cluster B" as cluster_0 {
+        state "Signed B & <co>." as s2
+        state "Signed A.\nSigned B & <co>." as s3
+        state "Signed B & <co>.\nSigned C." as s6
+    }
+    [*] --> s0
+    s0 --> s1 : Sign A
+    s0 --> s2 : Sign B
+    s1 --> s3 : Sign B
+    s3 --> s6 : Sign C
+    s6 --> s0 : Revoke
+@enduml
+`, buf.String())
+}
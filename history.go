@@ -0,0 +1,116 @@
+package multistate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HistoryRecordOf is a transition written to a history store. NewState of a
+// failed transition is the state the action would have resulted in, if it
+// was known.
+type HistoryRecordOf[S StateValue] struct {
+	EntityId  interface{}
+	Action    string
+	PrevState S
+	NewState  S
+	Opts      string
+	Actor     string
+	Time      time.Time
+	Error     string
+}
+
+type HistoryRecord = HistoryRecordOf[uint64]
+
+// HistoryStoreOf keeps the transition history. ListRecords returns the
+// records of the entity in the order they were added.
+type HistoryStoreOf[S StateValue] interface {
+	AddRecord(ctx context.Context, r HistoryRecordOf[S]) error
+	ListRecords(ctx context.Context, entityId interface{}) ([]HistoryRecordOf[S], error)
+}
+
+type HistoryStore = HistoryStoreOf[uint64]
+
+type HistoryEntry struct {
+	HistoryRecord
+	PrevFlags []StateFlag
+	NewFlags  []StateFlag
+}
+
+type actorKey struct{}
+
+// WithActor stores the actor recorded in the history of the actions done
+// with the context.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// SetHistoryStore makes DoAction write every transition to the store once
+// EndAction has committed it. A failed write of a committed transition is
+// returned as an ActionError in PhaseHistory together with the new state.
+// Failed actions are recorded too if recordFailures is set, errors of these
+// writes are ignored.
+func (m *machine[S]) SetHistoryStore(store HistoryStoreOf[S], recordFailures bool) {
+	m.history = store
+	m.historyFailures = recordFailures
+}
+
+// GetHistory returns the history of the entity with the state flags resolved.
+func (m *Multistate) GetHistory(ctx context.Context, entityId interface{}) ([]HistoryEntry, error) {
+	return listHistory(ctx, &m.machine, entityId, func(r HistoryRecord, prevFlags, newFlags []StateFlag) HistoryEntry {
+		return HistoryEntry{r, prevFlags, newFlags}
+	})
+}
+
+// listHistory reads the records of the entity and resolves their flags into
+// the entries.
+func listHistory[S StateValue, E any](ctx context.Context, m *machine[S], entityId interface{}, entry func(r HistoryRecordOf[S], prevFlags, newFlags []StateFlag) E) ([]E, error) {
+	if m.history == nil {
+		return nil, fmt.Errorf("history store is not set")
+	}
+
+	records, err := m.history.ListRecords(ctx, entityId)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]E, len(records))
+	for i, r := range records {
+		res[i] = entry(r, m.GetStateFlags(r.PrevState), m.GetStateFlags(r.NewState))
+	}
+
+	return res, nil
+}
+
+func (m *machine[S]) recordHistory(ctx context.Context, actionErr *ActionErrorOf[S], opts []interface{}, failed bool) error {
+	if m.history == nil || failed && !m.historyFailures {
+		return nil
+	}
+
+	r := HistoryRecordOf[S]{
+		EntityId:  actionErr.EntityId,
+		Action:    actionErr.Action,
+		PrevState: actionErr.FromState,
+		NewState:  actionErr.ToState,
+		Actor:     ActorFromContext(ctx),
+		Time:      time.Now(),
+	}
+	if len(opts) > 0 {
+		parts := make([]string, len(opts))
+		for i, opt := range opts {
+			parts[i] = fmt.Sprint(opt)
+		}
+		r.Opts = strings.Join(parts, ", ")
+	}
+	if failed {
+		r.Error = actionErr.Error()
+	}
+
+	return m.history.AddRecord(ctx, r)
+}
@@ -0,0 +1,155 @@
+package history_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-qbit/multistate"
+	"github.com/go-qbit/multistate/history"
+	"github.com/go-qbit/multistate/internal/testfixture"
+	"github.com/go-qbit/multistate/sqlstate"
+)
+
+func testStore(t *testing.T, store multistate.HistoryStore) {
+	mst := testfixture.NewSignMultistate()
+	mst.SetHistoryStore(store, true)
+
+	ctx := multistate.WithActor(context.Background(), "alice")
+	e := &testfixture.Entity{Id: 7}
+
+	_, err := mst.DoAction(ctx, e, "sign_a", "note", 42)
+	require.NoError(t, err)
+
+	_, err = mst.DoAction(ctx, e, "sign_b")
+	require.Error(t, err)
+
+	_, err = mst.DoAction(context.Background(), &testfixture.Entity{Id: 8}, "sign_a")
+	require.NoError(t, err)
+
+	entries, err := mst.GetHistory(context.Background(), 7)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	for i := range entries {
+		assert.False(t, entries[i].Time.IsZero())
+		entries[i].Time = time.Time{}
+	}
+
+	assert.Equal(t, []multistate.HistoryEntry{
+		{
+			HistoryRecord: multistate.HistoryRecord{EntityId: 7, Action: "sign_a", PrevState: 0, NewState: 1, Opts: "note, 42", Actor: "alice"},
+			PrevFlags:     []multistate.StateFlag{},
+			NewFlags:      []multistate.StateFlag{{Id: "signed_a", Bit: 0, Caption: "Signed A"}},
+		},
+		{
+			HistoryRecord: multistate.HistoryRecord{EntityId: 7, Action: "sign_b", PrevState: 1, NewState: 3, Actor: "alice", Error: "execute_action_error: rejected"},
+			PrevFlags:     []multistate.StateFlag{{Id: "signed_a", Bit: 0, Caption: "Signed A"}},
+			NewFlags:      []multistate.StateFlag{{Id: "signed_a", Bit: 0, Caption: "Signed A"}, {Id: "signed_b", Bit: 1, Caption: "Signed B"}},
+		},
+	}, entries)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, history.NewMemoryStore())
+}
+
+type failingEndEntity struct {
+	testfixture.Entity
+}
+
+func (*failingEndEntity) EndAction(_ context.Context, err error) error {
+	if err != nil {
+		return err
+	}
+	return errors.New("commit failed")
+}
+
+func TestMemoryStore_FailedEndAction(t *testing.T) {
+	mst := testfixture.NewSignMultistate()
+	store := history.NewMemoryStore()
+	mst.SetHistoryStore(store, true)
+
+	_, err := mst.DoAction(context.Background(), &failingEndEntity{testfixture.Entity{Id: 7}}, "sign_a")
+	require.EqualError(t, err, "commit failed")
+
+	records, err := store.ListRecords(context.Background(), 7)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "commit failed", records[0].Error)
+
+	mst.SetHistoryStore(store, false)
+	_, err = mst.DoAction(context.Background(), &failingEndEntity{testfixture.Entity{Id: 8}}, "sign_a")
+	require.Error(t, err)
+
+	records, err = store.ListRecords(context.Background(), 8)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+type failingStore struct {
+	*history.MemoryStore
+}
+
+func (failingStore) AddRecord(context.Context, multistate.HistoryRecord) error {
+	return errors.New("store is down")
+}
+
+func TestHistory_FailedWriteAfterCommit(t *testing.T) {
+	mst := testfixture.NewSignMultistate()
+	mst.SetHistoryStore(failingStore{history.NewMemoryStore()}, false)
+
+	e := &testfixture.Entity{Id: 7}
+	newState, err := mst.DoAction(context.Background(), e, "sign_a")
+	assert.EqualError(t, err, "store is down")
+	assert.Equal(t, uint64(1), newState)
+	assert.Equal(t, uint64(1), e.State)
+
+	var actionErr *multistate.ActionError
+	require.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, multistate.PhaseHistory, actionErr.Phase)
+}
+
+func TestSQLStore(t *testing.T) {
+	db := sql.OpenDB(testfixture.NewConnector(&historyTable{}))
+	defer db.Close()
+
+	testStore(t, history.NewSQLStore(db, sqlstate.PostgreSQL, "multistate_history"))
+}
+
+// historyTable emulates the history table, enough to run the queries of
+// SQLStore.
+type historyTable struct {
+	rows [][]driver.Value
+}
+
+func (h *historyTable) Exec(query string, args []driver.Value) (driver.Result, error) {
+	if query != "INSERT INTO multistate_history (entity_id, action, prev_state, new_state, opts, actor, created_at, error) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)" {
+		return nil, testfixture.UnexpectedQuery(query)
+	}
+	h.rows = append(h.rows, args)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (h *historyTable) Query(query string, args []driver.Value) (driver.Rows, error) {
+	if query != "SELECT action, prev_state, new_state, opts, actor, created_at, error FROM multistate_history WHERE entity_id = $1 ORDER BY id" {
+		return nil, testfixture.UnexpectedQuery(query)
+	}
+
+	var rows [][]driver.Value
+	for _, row := range h.rows {
+		if row[0] == args[0] {
+			rows = append(rows, row[1:])
+		}
+	}
+
+	return testfixture.Rows(strings.Split("action prev_state new_state opts actor created_at error", " "), rows), nil
+}
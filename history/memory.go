@@ -0,0 +1,48 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-qbit/multistate"
+)
+
+// MemoryStoreOf is a history store of a multistate keeping the records in
+// memory.
+type MemoryStoreOf[S multistate.StateValue] struct {
+	mu      sync.RWMutex
+	records map[string][]multistate.HistoryRecordOf[S]
+}
+
+// MemoryStore is the MemoryStoreOf of a multistate.Multistate.
+type MemoryStore = MemoryStoreOf[uint64]
+
+func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreOf[uint64]()
+}
+
+func NewMemoryStoreOf[S multistate.StateValue]() *MemoryStoreOf[S] {
+	return &MemoryStoreOf[S]{
+		records: make(map[string][]multistate.HistoryRecordOf[S]),
+	}
+}
+
+func (s *MemoryStoreOf[S]) AddRecord(_ context.Context, r multistate.HistoryRecordOf[S]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := fmt.Sprint(r.EntityId)
+	s.records[key] = append(s.records[key], r)
+
+	return nil
+}
+
+func (s *MemoryStoreOf[S]) ListRecords(_ context.Context, entityId interface{}) ([]multistate.HistoryRecordOf[S], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := s.records[fmt.Sprint(entityId)]
+
+	return append([]multistate.HistoryRecordOf[S](nil), records...), nil
+}
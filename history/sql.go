@@ -0,0 +1,98 @@
+// Package history holds the multistate.HistoryStore implementations. The
+// stores key the records by the fmt.Sprint representation of the entity ids,
+// so ids of different types printing alike, e.g. 1 and "1", share a history.
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/go-qbit/multistate"
+	"github.com/go-qbit/multistate/sqlstate"
+)
+
+// SQLStore is a multistate.HistoryStore writing to a database/sql table,
+// e.g. in PostgreSQL:
+//
+//	CREATE TABLE multistate_history (
+//		id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+//		entity_id  VARCHAR(255) NOT NULL,
+//		action     VARCHAR(255) NOT NULL,
+//		prev_state BIGINT NOT NULL,
+//		new_state  BIGINT NOT NULL,
+//		opts       TEXT NOT NULL,
+//		actor      VARCHAR(255) NOT NULL,
+//		created_at TIMESTAMP NOT NULL,
+//		error      TEXT NOT NULL
+//	)
+//
+// The id is BIGINT AUTO_INCREMENT in MySQL and INTEGER PRIMARY KEY
+// AUTOINCREMENT in SQLite. States are stored as int64 bit patterns and the
+// records are listed in the order of the id column.
+type SQLStore struct {
+	db      *sql.DB
+	dialect sqlstate.Dialect
+	table   string
+}
+
+func NewSQLStore(db *sql.DB, dialect sqlstate.Dialect, table string) *SQLStore {
+	return &SQLStore{db: db, dialect: dialect, table: table}
+}
+
+func (s *SQLStore) placeholders(n int) string {
+	res := make([]string, n)
+	for i := range res {
+		res[i] = s.dialect.Placeholder(i + 1)
+	}
+
+	return strings.Join(res, ", ")
+}
+
+func (s *SQLStore) AddRecord(ctx context.Context, r multistate.HistoryRecord) error {
+	query := fmt.Sprintf(
+		"INSERT INTO %s (entity_id, action, prev_state, new_state, opts, actor, created_at, error) VALUES (%s)",
+		s.table, s.placeholders(8),
+	)
+
+	if _, err := s.db.ExecContext(ctx, query,
+		fmt.Sprint(r.EntityId), r.Action, int64(r.PrevState), int64(r.NewState), r.Opts, r.Actor, r.Time, r.Error,
+	); err != nil {
+		return fmt.Errorf("cannot add history record: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLStore) ListRecords(ctx context.Context, entityId interface{}) ([]multistate.HistoryRecord, error) {
+	query := fmt.Sprintf(
+		"SELECT action, prev_state, new_state, opts, actor, created_at, error FROM %s WHERE entity_id = %s ORDER BY id",
+		s.table, s.placeholders(1),
+	)
+
+	rows, err := s.db.QueryContext(ctx, query, fmt.Sprint(entityId))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list history records: %w", err)
+	}
+	defer rows.Close()
+
+	var res []multistate.HistoryRecord
+	for rows.Next() {
+		var prevState, newState int64
+		r := multistate.HistoryRecord{EntityId: entityId}
+
+		if err := rows.Scan(&r.Action, &prevState, &newState, &r.Opts, &r.Actor, &r.Time, &r.Error); err != nil {
+			return nil, fmt.Errorf("cannot list history records: %w", err)
+		}
+		r.PrevState, r.NewState = uint64(prevState), uint64(newState)
+
+		res = append(res, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot list history records: %w", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,40 @@
+//go:build sqlite
+
+package history_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/go-qbit/multistate/history"
+	"github.com/go-qbit/multistate/sqlstate"
+)
+
+// The test runs against a real database with: go test -tags sqlite ./...
+
+func TestSQLStore_SQLite(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Every connection gets its own in-memory database
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE multistate_history (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		entity_id  TEXT NOT NULL,
+		action     TEXT NOT NULL,
+		prev_state INTEGER NOT NULL,
+		new_state  INTEGER NOT NULL,
+		opts       TEXT NOT NULL,
+		actor      TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		error      TEXT NOT NULL
+	)`)
+	require.NoError(t, err)
+
+	testStore(t, history.NewSQLStore(db, sqlstate.SQLite, "multistate_history"))
+}
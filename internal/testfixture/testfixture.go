@@ -0,0 +1,180 @@
+// Package testfixture holds the test helpers shared by the subpackages: an
+// in-memory entity, a small multistate and a fake database/sql driver.
+package testfixture
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/go-qbit/multistate"
+	. "github.com/go-qbit/multistate/expr"
+)
+
+// Entity keeps its state in memory.
+type Entity struct {
+	Id    int
+	State uint64
+}
+
+func (*Entity) StartAction(ctx context.Context) (context.Context, error) { return ctx, nil }
+func (e *Entity) GetState(context.Context) (uint64, error)               { return e.State, nil }
+func (e *Entity) SetState(_ context.Context, s uint64, _ ...interface{}) error {
+	e.State = s
+	return nil
+}
+func (*Entity) EndAction(_ context.Context, err error) error { return err }
+func (e *Entity) GetId() interface{}                         { return e.Id }
+
+// NewSignMultistate returns a compiled multistate with the actions sign_a,
+// setting signed_a, and sign_b, whose callback always fails with "rejected".
+func NewSignMultistate() *multistate.Multistate {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B")
+
+	mst.MustAddAction("sign_a", "Sign A", Not(signedA), multistate.States{signedA}, nil, nil, nil)
+	mst.MustAddAction("sign_b", "Sign B", signedA, multistate.States{signedB}, nil,
+		func(context.Context, multistate.Entity, ...interface{}) error { return errors.New("rejected") }, nil)
+	mst.MustCompile()
+
+	return mst
+}
+
+// Handler executes the statements received by a Connector.
+type Handler interface {
+	Exec(query string, args []driver.Value) (driver.Result, error)
+	Query(query string, args []driver.Value) (driver.Rows, error)
+}
+
+// TxHandler is implemented by the handlers supporting transactions.
+type TxHandler interface {
+	Handler
+	Begin() error
+	Commit() error
+	Rollback() error
+}
+
+// Connector is a database/sql connector passing the statements to the
+// handler and logging them, to test the SQL adapters without a database.
+type Connector struct {
+	Handler Handler
+
+	mu  sync.Mutex
+	log []string
+}
+
+func NewConnector(h Handler) *Connector {
+	return &Connector{Handler: h}
+}
+
+// Log returns the statements executed so far, with BEGIN, COMMIT and
+// ROLLBACK for the transactions.
+func (c *Connector) Log() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.log...)
+}
+
+func (c *Connector) ResetLog() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.log = nil
+}
+
+func (c *Connector) Connect(context.Context) (driver.Conn, error) { return conn{c}, nil }
+func (c *Connector) Driver() driver.Driver                        { return nil }
+
+// do logs the statement and runs it with the handler lock held.
+func (c *Connector) do(stmt string, f func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.log = append(c.log, stmt)
+
+	return f()
+}
+
+type conn struct {
+	c *Connector
+}
+
+func (c conn) Prepare(query string) (driver.Stmt, error) { return stmt{c.c, query}, nil }
+func (conn) Close() error                                { return nil }
+
+func (c conn) Begin() (driver.Tx, error) {
+	h, ok := c.c.Handler.(TxHandler)
+	if !ok {
+		return nil, errors.New("transactions are not supported")
+	}
+
+	return c, c.c.do("BEGIN", h.Begin)
+}
+
+func (c conn) Commit() error {
+	return c.c.do("COMMIT", c.c.Handler.(TxHandler).Commit)
+}
+
+func (c conn) Rollback() error {
+	return c.c.do("ROLLBACK", c.c.Handler.(TxHandler).Rollback)
+}
+
+type stmt struct {
+	c     *Connector
+	query string
+}
+
+func (stmt) Close() error  { return nil }
+func (stmt) NumInput() int { return -1 }
+
+func (s stmt) Exec(args []driver.Value) (res driver.Result, err error) {
+	err = s.c.do(s.query, func() error {
+		res, err = s.c.Handler.Exec(s.query, args)
+		return err
+	})
+
+	return res, err
+}
+
+func (s stmt) Query(args []driver.Value) (rows driver.Rows, err error) {
+	err = s.c.do(s.query, func() error {
+		rows, err = s.c.Handler.Query(s.query, args)
+		return err
+	})
+
+	return rows, err
+}
+
+// UnexpectedQuery is the error of a handler receiving an unknown statement.
+func UnexpectedQuery(query string) error {
+	return fmt.Errorf("unexpected query %s", query)
+}
+
+// Rows returns the rows of a query result.
+func Rows(columns []string, rows [][]driver.Value) driver.Rows {
+	return &rowsIter{columns, rows}
+}
+
+type rowsIter struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rowsIter) Columns() []string { return r.columns }
+func (*rowsIter) Close() error        { return nil }
+
+func (r *rowsIter) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
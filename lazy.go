@@ -0,0 +1,124 @@
+package multistate
+
+import (
+	"container/list"
+	"sync"
+)
+
+const defaultLazyCacheSize = 4096
+
+// WithLazyResolution skips the exhaustive precompilation. The actions of a
+// state are evaluated when DoAction or GetStateActions first meets it and
+// the result is kept in an LRU cache holding up to cacheSize states
+// (4096 if cacheSize isn't positive). The graph and query methods that need
+// the whole state space are unavailable in this mode.
+func WithLazyResolution(cacheSize int) CompileOption {
+	return func(o *compileOptions) {
+		o.lazy = true
+		o.lazyCacheSize = cacheSize
+		if o.lazyCacheSize <= 0 {
+			o.lazyCacheSize = defaultLazyCacheSize
+		}
+	}
+}
+
+// IsLazy reports whether the actions are evaluated on demand, so the graph
+// and query methods needing the whole state space are unavailable.
+func (m *machine[S]) IsLazy() bool {
+	return m.lazyCache != nil || m.onDemand && m.statesActions == nil
+}
+
+// transitions returns the actions available from the state mapped to the
+// resulting states.
+func (m *machine[S]) transitions(state S) (map[string]S, bool) {
+	switch {
+	case m.lazyCache != nil:
+		if !m.ops.isZero(m.ops.andNot(state, m.statesMask)) || m.checkEnums(state) != nil {
+			return nil, false
+		}
+
+		if actions, exists := m.lazyCache.get(state); exists {
+			return actions, true
+		}
+
+		actions := m.evalTransitions(state)
+		m.lazyCache.add(state, actions)
+
+		return actions, true
+	case m.onDemand && m.statesActions == nil:
+		return m.evalTransitions(state), true
+	}
+
+	actions, exists := m.statesActions[state]
+	return actions, exists
+}
+
+func (m *machine[S]) evalTransitions(state S) map[string]S {
+	actions := make(map[string]S)
+	for _, action := range m.actionsMap {
+		if !m.ops.eval(action.from, state) {
+			continue
+		}
+		if action.automatic {
+			continue
+		}
+		// An action leaving an enum undeclared or looping through the
+		// automatic actions is never available
+		_, newState, err := m.automaticChain(action.apply(state))
+		if err == nil && m.checkEnums(newState) == nil {
+			actions[action.id] = newState
+		}
+	}
+
+	return actions
+}
+
+type stateCache[S StateValue] struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[S]*list.Element
+}
+
+type stateCacheEntry[S StateValue] struct {
+	state   S
+	actions map[string]S
+}
+
+func newStateCache[S StateValue](size int) *stateCache[S] {
+	return &stateCache[S]{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[S]*list.Element),
+	}
+}
+
+func (c *stateCache[S]) get(state S) (map[string]S, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, exists := c.items[state]
+	if !exists {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+
+	return el.Value.(*stateCacheEntry[S]).actions, true
+}
+
+func (c *stateCache[S]) add(state S, actions map[string]S) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, exists := c.items[state]; exists {
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[state] = c.ll.PushFront(&stateCacheEntry[S]{state, actions})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*stateCacheEntry[S]).state)
+	}
+}
@@ -0,0 +1,79 @@
+package multistate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads a YAML or JSON definition and builds the compiled multistate.
+// Expressions may be written either in the textual form accepted by
+// expr.Parse or as expr.Node objects.
+func Load(r io.Reader, registry Registry) (*Multistate, error) {
+	def, err := ReadDefinition(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFromDefinition(def, registry)
+}
+
+func MustLoad(r io.Reader, registry Registry) *Multistate {
+	mst, err := Load(r, registry)
+	if err != nil {
+		panic(err)
+	}
+
+	return mst
+}
+
+// ReadDefinition decodes a YAML or JSON definition. JSON is read as a subset
+// of YAML and both are validated against the JSON field names of Definition.
+func ReadDefinition(r io.Reader) (Definition, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return Definition{}, fmt.Errorf("cannot read definition: %w", err)
+	}
+	if err := checkTags(&doc); err != nil {
+		return Definition{}, fmt.Errorf("invalid definition: %w", err)
+	}
+
+	var raw interface{}
+	if err := doc.Decode(&raw); err != nil {
+		return Definition{}, fmt.Errorf("cannot read definition: %w", err)
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return Definition{}, fmt.Errorf("cannot read definition: %w", err)
+	}
+
+	var def Definition
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&def); err != nil {
+		return Definition{}, fmt.Errorf("invalid definition: %w", err)
+	}
+
+	return def, nil
+}
+
+// checkTags rejects the local YAML tags. An unquoted expression starting with
+// '!' is read as a tag, e.g. "from: !approved" is an empty string tagged
+// "!approved".
+func checkTags(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode && strings.HasPrefix(n.Tag, "!") && !strings.HasPrefix(n.Tag, "!!") {
+		return fmt.Errorf("line %d: the value '%s' must be quoted", n.Line, strings.TrimSpace(n.Tag+" "+n.Value))
+	}
+	for _, c := range n.Content {
+		if err := checkTags(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
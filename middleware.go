@@ -0,0 +1,31 @@
+package multistate
+
+import "context"
+
+// ActionCallOf describes an action being executed by DoAction. FromState and
+// ToState are updated once the new state is written.
+type ActionCallOf[S StateValue] struct {
+	Action    string
+	Entity    EntityOf[S]
+	FromState S
+	ToState   S
+	Opts      []interface{}
+}
+
+type ActionCall = ActionCallOf[uint64]
+
+type ActionHandlerOf[S StateValue] func(ctx context.Context, call *ActionCallOf[S]) error
+
+type ActionHandler = ActionHandlerOf[uint64]
+
+// MiddlewareOf wraps the execution of a validated action: the OnDoCallback,
+// the ActionDoFunc and the state write. It may stop the action by returning
+// an error without calling next, or pass modified Opts on.
+type MiddlewareOf[S StateValue] func(next ActionHandlerOf[S]) ActionHandlerOf[S]
+
+type Middleware = MiddlewareOf[uint64]
+
+// Use appends middlewares, the first one is the outermost.
+func (m *machine[S]) Use(mw ...MiddlewareOf[S]) {
+	m.middlewares = append(m.middlewares, mw...)
+}
@@ -2,25 +2,50 @@ package multistate
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/go-qbit/multistate/expr"
 )
 
 var reStateAction = regexp.MustCompile(`^[a-z\d_-]+$`)
 
+// Multistate is a multistate of up to 64 flags, its states are uint64
+// bitmasks. Use BitsetMultistate for more flags.
 type Multistate struct {
-	emptyStateName  string
-	statesMap       map[string]*state
-	statesBitsMap   map[uint8]*state
-	actionsMap      map[string]*action
-	statesActions   map[uint64]map[string]uint64
-	clusters        []cluster
-	stateClusterMap map[uint64]*cluster
-	onDo            OnDoCallback
+	machine[uint64]
+}
+
+// machine implements the multistates over the state type, the states are
+// handled through ops.
+type machine[S StateValue] struct {
+	ops               stateOps[S]
+	emptyStateName    string
+	statesMap         map[string]*state
+	statesBitsMap     map[uint8]*state
+	actionsMap        map[string]*action[S]
+	statesActions     map[S]map[string]S
+	clusters          []cluster
+	stateClusterMap   map[S]*cluster
+	onDo              OnDoCallbackOf[S]
+	compileStats      CompileStats
+	lazyCache         *stateCache[S]
+	onDemand          bool
+	statesMask        S
+	conflictRetries   int
+	middlewares       []MiddlewareOf[S]
+	events            eventBus[S]
+	onEnter           map[uint8][]StateChangeFuncOf[S]
+	onExit            map[uint8][]StateChangeFuncOf[S]
+	history           HistoryStoreOf[S]
+	enums             []*Enum
+	automaticIds      []string
+	maxAutomaticSteps int
+	historyFailures   bool
 }
 
 type StateFlag struct {
@@ -29,7 +54,9 @@ type StateFlag struct {
 	Caption string
 }
 
-type OnDoCallback func(ctx context.Context, entity Entity, prevState, newState uint64, action string, opts ...interface{}) error
+type OnDoCallbackOf[S StateValue] func(ctx context.Context, entity EntityOf[S], prevState, newState S, action string, opts ...interface{}) error
+
+type OnDoCallback = OnDoCallbackOf[uint64]
 
 type cluster struct {
 	id         uint8
@@ -38,35 +65,54 @@ type cluster struct {
 }
 
 func New(emptyStateName string) *Multistate {
-	return &Multistate{
-		emptyStateName: emptyStateName,
-		statesMap:      make(map[string]*state),
-		statesBitsMap:  make(map[uint8]*state),
-		actionsMap:     make(map[string]*action),
-	}
+	m := &Multistate{}
+	m.init(emptyStateName)
+
+	return m
 }
 
-func (m *Multistate) SetOnDoCallback(cb OnDoCallback) {
+func (m *machine[S]) init(emptyStateName string) {
+	m.ops = newStateOps[S]()
+	m.emptyStateName = emptyStateName
+	m.statesMap = make(map[string]*state)
+	m.statesBitsMap = make(map[uint8]*state)
+	m.actionsMap = make(map[string]*action[S])
+	m.maxAutomaticSteps = defaultMaxAutomaticSteps
+}
+
+func (m *machine[S]) SetOnDoCallback(cb OnDoCallbackOf[S]) {
 	m.onDo = cb
 }
 
-func (m *Multistate) AddState(bit uint8, id, caption string) (*state, error) {
+// SetConflictRetries sets how many times DoAction starts over after a
+// CASEntity reports a concurrent state change. Each attempt reads the state
+// again and repeats the guards, middlewares, callbacks and hooks; the
+// compensations of the stale attempt run before the next one.
+func (m *machine[S]) SetConflictRetries(n int) {
+	m.conflictRetries = n
+}
+
+func (m *machine[S]) AddState(bit uint8, id, caption string) (*state, error) {
 	if !reStateAction.MatchString(id) {
 		return nil, fmt.Errorf("invalid characters in state id '%s', must be %s", id, reStateAction.String())
 	}
 
-	if bit > 63 {
-		return nil, fmt.Errorf("bit must be less than 64")
+	if int(bit) >= m.ops.size() {
+		return nil, fmt.Errorf("bit must be less than %d", m.ops.size())
 	}
 
 	if _, exists := m.statesMap[id]; id == "empty" || id == "any" || exists {
 		return nil, fmt.Errorf("state '%s' already exists", id)
 	}
 
-	if _, exists := m.statesBitsMap[bit]; exists {
+	if _, exists := m.statesBitsMap[bit]; exists || m.enumBits()&(1<<bit) != 0 {
 		return nil, fmt.Errorf("bit '%d' already busy", bit)
 	}
 
+	if m.enumById(id) != nil {
+		return nil, fmt.Errorf("state '%s' already exists", id)
+	}
+
 	s := &state{id, caption, bit}
 	m.statesMap[id] = s
 	m.statesBitsMap[bit] = s
@@ -74,7 +120,7 @@ func (m *Multistate) AddState(bit uint8, id, caption string) (*state, error) {
 	return s, nil
 }
 
-func (m *Multistate) MustAddState(bit uint8, id, caption string) *state {
+func (m *machine[S]) MustAddState(bit uint8, id, caption string) *state {
 	s, err := m.AddState(bit, id, caption)
 	if err != nil {
 		panic(err)
@@ -83,7 +129,7 @@ func (m *Multistate) MustAddState(bit uint8, id, caption string) *state {
 	return s
 }
 
-func (m *Multistate) AddAction(id, caption string, from expr.Expression, set, reset States, onDo ActionDoFunc, avail Availabler) error {
+func (m *machine[S]) AddAction(id, caption string, from expr.Expression, set, reset States, onDo ActionDoFuncOf[S], avail Availabler) error {
 	if !reStateAction.MatchString(id) {
 		return fmt.Errorf("invalid characters in action id '%s', must be %s", id, reStateAction.String())
 	}
@@ -92,27 +138,50 @@ func (m *Multistate) AddAction(id, caption string, from expr.Expression, set, re
 		return fmt.Errorf("action '%s' already exists", id)
 	}
 
-	a := &action{
+	a := &action[S]{
 		id:         id,
 		caption:    caption,
 		from:       from,
-		set:        make([]uint64, len(set)),
-		reset:      make([]uint64, len(reset)),
+		set:        make([]S, len(set)),
+		reset:      make([]S, len(reset)),
+		ops:        m.ops,
 		do:         onDo,
 		availabler: avail,
 	}
 
 	for i, s := range set {
-		if state, exists := m.statesMap[s.GetStateId()]; exists {
-			a.set[i] = 1 << state.bit
+		if v, ok := s.(enumValue); ok {
+			if m.enumById(v.enum.id) != v.enum {
+				return fmt.Errorf("enum '%s' doesn't exists", v.enum.id)
+			}
+			if m.ops.low(a.setMask())&v.enum.mask != 0 {
+				return fmt.Errorf("enum '%s' is set twice", v.enum.id)
+			}
+			// Clear the other bits of the field
+			a.set[i] = m.ops.fromLow(v.value)
+			a.reset = append(a.reset, m.ops.fromLow(v.enum.mask&^v.value))
+		} else if e, ok := s.(*Enum); ok {
+			return fmt.Errorf("enum '%s' needs a value to be set", e.id)
+		} else if state, exists := m.statesMap[s.GetStateId()]; exists {
+			a.set[i] = m.ops.flag(state.bit)
 		} else {
 			return fmt.Errorf("state '%s' doesn't exists", s.GetStateId())
 		}
 	}
 
 	for i, s := range reset {
-		if state, exists := m.statesMap[s.GetStateId()]; exists {
-			a.reset[i] = ^(1 << state.bit)
+		if _, ok := s.(enumValue); ok {
+			return fmt.Errorf("enum '%s' can only be set", s.GetStateId())
+		} else if e, ok := s.(*Enum); ok {
+			if m.enumById(e.id) != e {
+				return fmt.Errorf("enum '%s' doesn't exists", e.id)
+			}
+			if m.ops.low(a.setMask())&e.mask != 0 {
+				return fmt.Errorf("enum '%s' is set and reset", e.id)
+			}
+			a.reset[i] = m.ops.fromLow(e.mask)
+		} else if state, exists := m.statesMap[s.GetStateId()]; exists {
+			a.reset[i] = m.ops.flag(state.bit)
 		} else {
 			return fmt.Errorf("state '%s' doesn't exists", s.GetStateId())
 		}
@@ -123,13 +192,13 @@ func (m *Multistate) AddAction(id, caption string, from expr.Expression, set, re
 	return nil
 }
 
-func (m *Multistate) MustAddAction(id, caption string, from expr.Expression, set, reset []State, onDo ActionDoFunc, avail Availabler) {
+func (m *machine[S]) MustAddAction(id, caption string, from expr.Expression, set, reset []State, onDo ActionDoFuncOf[S], avail Availabler) {
 	if err := m.AddAction(id, caption, from, set, reset, onDo, avail); err != nil {
 		panic(err)
 	}
 }
 
-func (m *Multistate) AddCluster(name string, expr expr.Expression) {
+func (m *machine[S]) AddCluster(name string, expr expr.Expression) {
 	m.clusters = append(m.clusters, cluster{
 		id:         uint8(len(m.clusters)),
 		name:       name,
@@ -137,78 +206,181 @@ func (m *Multistate) AddCluster(name string, expr expr.Expression) {
 	})
 }
 
-func (m *Multistate) Compile() error {
-	if m.statesActions != nil {
+type CompileOption func(*compileOptions)
+
+type compileOptions struct {
+	maxStates         int
+	lazy              bool
+	lazyCacheSize     int
+	maxAutomaticSteps int
+}
+
+// WithMaxStates aborts Compile with ErrTooManyStates when more than n states
+// are reachable.
+func WithMaxStates(n int) CompileOption {
+	return func(o *compileOptions) {
+		o.maxStates = n
+	}
+}
+
+type CompileStats struct {
+	States      int
+	Transitions int
+	Duration    time.Duration
+}
+
+func (m *machine[S]) Compile(opts ...CompileOption) error {
+	if m.compiled() {
 		return fmt.Errorf("multistate is already compiled")
 	}
 
-	m.statesActions = make(map[uint64]map[string]uint64)
-	m.statesActions[0] = make(map[string]uint64)
-
-	changed := true
-	for changed {
-		// println()
-		changed = false
-
-		for _, action := range m.actionsMap {
-			for state, actions := range m.statesActions {
-				if action.from.Eval(state) {
-					if _, exists := actions[action.id]; !exists {
-						changed = true
-						newState := state
-						for _, v := range action.set {
-							// println("set", v)
-							newState |= v
-						}
-						for _, v := range action.reset {
-							newState &= v
-							// println("reset", v)
-						}
-
-						// println("from", state, "to", newState, "by", action.id)
-
-						actions[action.id] = newState
-						if _, exists := m.statesActions[newState]; !exists {
-							m.statesActions[newState] = make(map[string]uint64)
-						}
-					}
+	var o compileOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	started := time.Now()
+
+	for _, state := range m.statesMap {
+		m.statesMask = m.ops.or(m.statesMask, m.ops.flag(state.bit))
+	}
+	m.statesMask = m.ops.or(m.statesMask, m.ops.fromLow(m.enumBits()))
+
+	if o.maxAutomaticSteps > 0 {
+		m.maxAutomaticSteps = o.maxAutomaticSteps
+	}
+	var actionIds []string
+	for _, id := range m.sortedActionIds() {
+		if !m.actionsMap[id].automatic {
+			actionIds = append(actionIds, id)
+		}
+	}
+
+	if o.lazy {
+		m.lazyCache = newStateCache[S](o.lazyCacheSize)
+		m.compileStats = CompileStats{Duration: time.Since(started)}
+		return nil
+	}
+
+	var empty S
+	m.statesActions = make(map[S]map[string]S)
+	m.statesActions[empty] = make(map[string]S)
+
+	var transitions int
+	queue := []S{empty}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		actions := m.statesActions[state]
+		for _, id := range actionIds {
+			action := m.actionsMap[id]
+			if !m.ops.eval(action.from, state) {
+				continue
+			}
+
+			_, newState, err := m.automaticChain(action.apply(state))
+			if err != nil {
+				m.statesActions = nil
+				return fmt.Errorf("action '%s' from %v: %w", action.id, state, err)
+			}
+			if err := m.checkEnums(newState); err != nil {
+				m.statesActions = nil
+				return fmt.Errorf("action '%s' from %v: %w", action.id, state, err)
+			}
+			actions[action.id] = newState
+			transitions++
+
+			if _, exists := m.statesActions[newState]; !exists {
+				if o.maxStates > 0 && len(m.statesActions) >= o.maxStates {
+					m.statesActions = nil
+					return fmt.Errorf("more than %d states are reachable, last found %v by action '%s' from %v: %w",
+						o.maxStates, newState, action.id, state, ErrTooManyStates)
 				}
+				m.statesActions[newState] = make(map[string]S)
+				queue = append(queue, newState)
 			}
 		}
 	}
 
-	m.stateClusterMap = map[uint64]*cluster{}
+	m.stateClusterMap = map[S]*cluster{}
 	for i, cluster := range m.clusters {
 		for state := range m.statesActions {
-			if !cluster.expression.Eval(state) {
+			if !m.ops.eval(cluster.expression, state) {
 				continue
 			}
 			if c, exists := m.stateClusterMap[state]; exists {
-				return fmt.Errorf("the state %d exists at least in 2 clusters: %s and %s", state, c.name, cluster.name)
+				return fmt.Errorf("the state %v exists at least in 2 clusters: %s and %s", state, c.name, cluster.name)
 			}
 			m.stateClusterMap[state] = &m.clusters[i]
 		}
 	}
 
+	m.compileStats = CompileStats{
+		States:      len(m.statesActions),
+		Transitions: transitions,
+		Duration:    time.Since(started),
+	}
+
 	return nil
 }
 
-func (m *Multistate) MustCompile() {
-	if err := m.Compile(); err != nil {
+func (m *machine[S]) MustCompile(opts ...CompileOption) {
+	if err := m.Compile(opts...); err != nil {
 		panic(err)
 	}
 }
 
-func (m *Multistate) GetStateActions(ctx context.Context, state uint64) []string {
-	if actions, exists := m.statesActions[state]; exists {
+func (m *machine[S]) compiled() bool {
+	return m.statesActions != nil || m.lazyCache != nil
+}
+
+func (m *machine[S]) GetCompileStats() CompileStats {
+	return m.compileStats
+}
+
+func (m *machine[S]) sortedActionIds() []string {
+	res := make([]string, 0, len(m.actionsMap))
+	for id := range m.actionsMap {
+		res = append(res, id)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+func (m *machine[S]) GetStateActions(ctx context.Context, state S) []string {
+	return m.availableActions(ctx, nil, state)
+}
+
+// GetEntityActions loads the state of the entity and returns the actions
+// available for it, EntityAvailabler guards included.
+func (m *machine[S]) GetEntityActions(ctx context.Context, entity EntityOf[S]) ([]string, error) {
+	ctx, err := entity.StartAction(ctx)
+	if err != nil {
+		return nil, entity.EndAction(ctx, err)
+	}
+
+	state, err := entity.GetState(ctx)
+	if err != nil {
+		return nil, entity.EndAction(ctx, err)
+	}
+
+	res := m.availableActions(ctx, entity, state)
+
+	return res, entity.EndAction(ctx, nil)
+}
+
+func (m *machine[S]) availableActions(ctx context.Context, entity EntityOf[S], state S) []string {
+	if actions, exists := m.transitions(state); exists {
 		res := make([]string, 0, len(actions))
 
 		for actionId := range actions {
-			action := m.actionsMap[actionId]
-			if action.availabler == nil || action.availabler.IsAvailable(ctx) {
+			if len(m.actionsMap[actionId].unavailableReasons(ctx, entity, state)) == 0 {
 				res = append(res, actionId)
 			}
 		}
+		sort.Strings(res)
 
 		return res
 	}
@@ -216,51 +388,221 @@ func (m *Multistate) GetStateActions(ctx context.Context, state uint64) []string
 	return nil
 }
 
-func (m *Multistate) DoAction(ctx context.Context, entity Entity, action string, opts ...interface{}) (uint64, error) {
+type ActionAvailabilityOf[S StateValue] struct {
+	Action    string
+	Caption   string
+	NewState  S
+	Available bool
+	Reasons   []Reason
+}
+
+type ActionAvailability = ActionAvailabilityOf[uint64]
+
+// GetStateActionsDetailed returns every action valid for the state, the
+// unavailable ones included together with the reasons they are blocked.
+func (m *machine[S]) GetStateActionsDetailed(ctx context.Context, state S) []ActionAvailabilityOf[S] {
+	return m.actionsDetailed(ctx, nil, state)
+}
+
+// GetEntityActionsDetailed is GetStateActionsDetailed for the current state
+// of the entity, EntityAvailabler guards included.
+func (m *machine[S]) GetEntityActionsDetailed(ctx context.Context, entity EntityOf[S]) ([]ActionAvailabilityOf[S], error) {
+	ctx, err := entity.StartAction(ctx)
+	if err != nil {
+		return nil, entity.EndAction(ctx, err)
+	}
+
+	state, err := entity.GetState(ctx)
+	if err != nil {
+		return nil, entity.EndAction(ctx, err)
+	}
+
+	res := m.actionsDetailed(ctx, entity, state)
+
+	return res, entity.EndAction(ctx, nil)
+}
+
+func (m *machine[S]) actionsDetailed(ctx context.Context, entity EntityOf[S], state S) []ActionAvailabilityOf[S] {
+	actions, exists := m.transitions(state)
+	if !exists {
+		return nil
+	}
+
+	res := make([]ActionAvailabilityOf[S], 0, len(actions))
+	for actionId, newState := range actions {
+		action := m.actionsMap[actionId]
+		reasons := action.unavailableReasons(ctx, entity, state)
+		res = append(res, ActionAvailabilityOf[S]{
+			Action:    actionId,
+			Caption:   action.caption,
+			NewState:  newState,
+			Available: len(reasons) == 0,
+			Reasons:   reasons,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Action < res[j].Action })
+
+	return res
+}
+
+func (m *machine[S]) DoAction(ctx context.Context, entity EntityOf[S], action string, opts ...interface{}) (S, error) {
+	actionErr := &ActionErrorOf[S]{Action: action, EntityId: entity.GetId()}
+	var done compensations[S]
+	var zero S
+	fail := func(phase ActionPhase, cause error) (S, error) {
+		actionErr.Phase, actionErr.Cause = phase, cause
+		actionErr.Compensation = errors.Join(actionErr.Compensation, done.run(ctx, m, entity))
+		err := entity.EndAction(ctx, actionErr)
+		_ = m.recordHistory(ctx, actionErr, opts, true)
+		return zero, err
+	}
+
 	ctx, err := entity.StartAction(ctx)
 	if err != nil {
-		return 0, entity.EndAction(ctx, err)
+		return fail(PhaseStart, err)
+	}
+
+	var call *ActionCallOf[S]
+	for attempt := 0; ; attempt++ {
+		var phase ActionPhase
+		if call, phase, err = m.doAttempt(ctx, entity, action, opts, actionErr, &done); err == nil {
+			break
+		}
+
+		if attempt >= m.conflictRetries || phase != PhaseSetState || !errors.Is(err, ErrStateConflict) {
+			return fail(phase, err)
+		}
+
+		// The callbacks ran for a stale state, undo them before starting over
+		if actionErr.Compensation = done.run(ctx, m, entity); actionErr.Compensation != nil {
+			return fail(phase, err)
+		}
+	}
+
+	if err := entity.EndAction(ctx, nil); err != nil {
+		actionErr.Phase, actionErr.Cause = PhaseEnd, err
+		actionErr.Compensation = done.run(ctx, m, entity)
+		_ = m.recordHistory(ctx, actionErr, call.Opts, true)
+		return zero, actionErr
+	}
+
+	m.publishTransition(ctx, actionErr.EntityId, action, call.FromState, call.ToState)
+
+	// The transition is committed, so a failed history write is only reported
+	if err := m.recordHistory(ctx, actionErr, call.Opts, false); err != nil {
+		actionErr.Phase, actionErr.Cause = PhaseHistory, err
+		return call.ToState, actionErr
 	}
 
+	return call.ToState, nil
+}
+
+// doAttempt reads the state, validates the action and runs the handler with
+// the middlewares. DoAction repeats it from scratch when a CASEntity reports
+// a conflict, so the guards, callbacks and hooks always see the state that
+// is finally written.
+func (m *machine[S]) doAttempt(ctx context.Context, entity EntityOf[S], action string, opts []interface{}, actionErr *ActionErrorOf[S], done *compensations[S]) (*ActionCallOf[S], ActionPhase, error) {
+	var zero S
+	actionErr.FromState, actionErr.ToState = zero, zero
+
 	curState, err := entity.GetState(ctx)
 	if err != nil {
-		return 0, entity.EndAction(ctx, err)
+		return nil, PhaseGetState, err
 	}
+	actionErr.FromState = curState
 
-	actions, exists := m.statesActions[curState]
+	actions, exists := m.transitions(curState)
 	if !exists {
-		return 0, entity.EndAction(ctx, fmt.Errorf("current state %d: %w", curState, ErrInvalidState))
+		return nil, PhaseValidate, fmt.Errorf("current state %v: %w", curState, ErrInvalidState)
 	}
 
 	newState, exists := actions[action]
 	if !exists {
-		return 0, entity.EndAction(ctx, fmt.Errorf("action '%s', current state %d: %w", action, curState, ErrInvalidAction))
+		if a, known := m.actionsMap[action]; known {
+			return nil, PhaseValidate, fmt.Errorf("action '%s' requires '%s', current state %v: %w", action, expr.String(a.from), curState, ErrInvalidAction)
+		}
+		return nil, PhaseValidate, fmt.Errorf("action '%s', current state %v: %w", action, curState, ErrInvalidAction)
+	}
+	actionErr.ToState = newState
+
+	if reasons := m.actionsMap[action].unavailableReasons(ctx, entity, curState); len(reasons) > 0 {
+		return nil, PhaseAvailability, fmt.Errorf("action '%s', current state %v: %w", action, curState, &UnavailableError{reasons})
 	}
 
-	if avail := m.actionsMap[action].availabler; avail != nil && !avail.IsAvailable(ctx) {
-		return 0, entity.EndAction(ctx, fmt.Errorf("action '%s', current state %d: %w", action, curState, ErrNotAvailable))
+	var failedPhase ActionPhase
+	var handler ActionHandlerOf[S] = func(ctx context.Context, call *ActionCallOf[S]) error {
+		if m.onDo != nil {
+			if err := m.onDo(ctx, entity, curState, newState, action, call.Opts...); err != nil {
+				failedPhase = PhaseOnDo
+				return fmt.Errorf("%w: %w", ErrExecutionAction, err)
+			}
+		}
+
+		if onAction := m.actionsMap[action].do; onAction != nil {
+			if err := onAction(ctx, entity, call.Opts...); err != nil {
+				failedPhase = PhaseDo
+				return fmt.Errorf("%w: %w", ErrExecutionAction, err)
+			}
+			done.push(m, action, call.Opts)
+		}
+
+		if phase, err := m.runAutomatic(ctx, entity, m.actionsMap[action].apply(curState), call.Opts, done); err != nil {
+			failedPhase = phase
+			return err
+		}
+
+		if err := m.commitState(ctx, entity, action, curState, newState); err != nil {
+			failedPhase = PhaseSetState
+			return err
+		}
+
+		if phase, err := m.runFlagHooks(ctx, entity, curState, newState, action); err != nil {
+			failedPhase = phase
+			return err
+		}
+
+		return nil
+	}
+	for i := len(m.middlewares) - 1; i >= 0; i-- {
+		handler = m.middlewares[i](handler)
 	}
 
-	if m.onDo != nil {
-		if err := m.onDo(ctx, entity, curState, newState, action, opts...); err != nil {
-			return 0, entity.EndAction(ctx, fmt.Errorf("%w: %w", ErrExecutionAction, err))
+	call := &ActionCallOf[S]{Action: action, Entity: entity, FromState: curState, ToState: newState, Opts: opts}
+	if err := handler(ctx, call); err != nil {
+		if failedPhase == "" {
+			failedPhase = PhaseMiddleware
 		}
+		return nil, failedPhase, err
 	}
+	call.FromState, call.ToState = curState, newState
 
-	if onAction := m.actionsMap[action].do; onAction != nil {
-		if err := onAction(ctx, entity, opts...); err != nil {
-			return 0, entity.EndAction(ctx, fmt.Errorf("%w: %w", ErrExecutionAction, err))
+	return call, "", nil
+}
+
+// commitState writes the new state. A CASEntity is only updated if it is
+// still in the state the action was evaluated for, otherwise ErrStateConflict
+// is returned.
+func (m *machine[S]) commitState(ctx context.Context, entity EntityOf[S], action string, curState, newState S) error {
+	casEntity, ok := entity.(CASEntityOf[S])
+	if !ok {
+		if err := entity.SetState(ctx, newState); err != nil {
+			return fmt.Errorf("%w: %w", ErrSetState, err)
 		}
+		return nil
 	}
 
-	if err := entity.SetState(ctx, newState); err != nil {
-		return 0, entity.EndAction(ctx, fmt.Errorf("%w: %w", ErrSetState, err))
+	swapped, err := casEntity.CompareAndSetState(ctx, curState, newState)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSetState, err)
+	}
+	if !swapped {
+		return fmt.Errorf("action '%s', expected state %v: %w", action, curState, ErrStateConflict)
 	}
 
-	return newState, entity.EndAction(ctx, nil)
+	return nil
 }
 
-func (m *Multistate) GetAllStateFlags() []StateFlag {
+func (m *machine[S]) GetAllStateFlags() []StateFlag {
 	res := make([]StateFlag, 0, len(m.statesMap))
 
 	for _, state := range m.statesMap {
@@ -278,14 +620,14 @@ func (m *Multistate) GetAllStateFlags() []StateFlag {
 	return res
 }
 
-func (m *Multistate) GetStateFlags(id uint64) []StateFlag {
-	if id == 0 {
+func (m *machine[S]) GetStateFlags(id S) []StateFlag {
+	if m.ops.isZero(id) {
 		return []StateFlag{}
 	}
 
-	var res []StateFlag
+	res := m.enumFlags(m.ops.low(id))
 	for _, state := range m.statesMap {
-		if id&(1<<state.bit) > 0 {
+		if m.ops.has(id, state.bit) {
 			res = append(res, StateFlag{
 				Id:      state.id,
 				Bit:     state.bit,
@@ -301,7 +643,7 @@ func (m *Multistate) GetStateFlags(id uint64) []StateFlag {
 	return res
 }
 
-func (m *Multistate) GetStateName(id uint64) string {
+func (m *machine[S]) GetStateName(id S) string {
 	flags := m.GetStateFlags(id)
 
 	if len(flags) == 0 {
@@ -319,12 +661,31 @@ func (m *Multistate) GetStateName(id uint64) string {
 	return strings.Join(stateNames, ".\n") + "."
 }
 
-func (m *Multistate) GetActionName(id string) string {
+func (m *machine[S]) GetActionName(id string) string {
 	return m.actionsMap[id].caption
 }
 
-func (m *Multistate) GetStatesByActions(actions ...string) []uint64 {
-	set := make(map[uint64]struct{})
+func (m *machine[S]) HasAction(id string) bool {
+	_, exists := m.actionsMap[id]
+	return exists
+}
+
+// GetStatesByActions returns nil in the lazy mode, use StatesByActions to
+// tell it from no states.
+func (m *machine[S]) GetStatesByActions(actions ...string) []S {
+	states, _ := m.StatesByActions(actions...)
+
+	return states
+}
+
+// StatesByActions returns the states where any of the actions can be done.
+// It needs the compiled graph and fails with ErrLazyMode in the lazy mode.
+func (m *machine[S]) StatesByActions(actions ...string) ([]S, error) {
+	if m.IsLazy() {
+		return nil, ErrLazyMode
+	}
+
+	set := make(map[S]struct{})
 
 	for _, action := range actions {
 		for state, mapact := range m.statesActions {
@@ -334,38 +695,56 @@ func (m *Multistate) GetStatesByActions(actions ...string) []uint64 {
 		}
 	}
 
-	ret := make([]uint64, 0, len(set))
+	ret := make([]S, 0, len(set))
 	for el := range set {
 		ret = append(ret, el)
 	}
-	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	m.sortStates(ret)
 
-	return ret
+	return ret, nil
 }
 
-func (m *Multistate) GetMultistatesByStateIds(stateIds ...string) []uint64 {
-	var bitmask uint64
+// GetMultistatesByStateIds returns nil in the lazy mode, use
+// MultistatesByStateIds to tell it from no states.
+func (m *machine[S]) GetMultistatesByStateIds(stateIds ...string) []S {
+	states, _ := m.MultistatesByStateIds(stateIds...)
+
+	return states
+}
+
+// MultistatesByStateIds returns the states having any of the flags. It needs
+// the compiled graph and fails with ErrLazyMode in the lazy mode.
+func (m *machine[S]) MultistatesByStateIds(stateIds ...string) ([]S, error) {
+	if m.IsLazy() {
+		return nil, ErrLazyMode
+	}
+
+	var bitmask S
 
 	for _, id := range stateIds {
 		if st, ok := m.statesMap[id]; ok {
-			bitmask |= 1 << st.bit
+			bitmask = m.ops.or(bitmask, m.ops.flag(st.bit))
 		}
 	}
 
-	var ret []uint64
+	var ret []S
 
 	for multistate := range m.statesActions {
-		if multistate&bitmask != 0 {
+		if !m.ops.isZero(m.ops.and(multistate, bitmask)) {
 			ret = append(ret, multistate)
 		}
 	}
-	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	m.sortStates(ret)
 
-	return ret
+	return ret, nil
 }
 
-func (m *Multistate) GetMultistatesByRequiredAndForbiddenStateIds(reqIds, forbIds []string) ([]uint64, error) {
-	var requiredBitmask, forbiddenBitmask uint64
+func (m *machine[S]) GetMultistatesByRequiredAndForbiddenStateIds(reqIds, forbIds []string) ([]S, error) {
+	if m.IsLazy() {
+		return nil, ErrLazyMode
+	}
+
+	var requiredBitmask, forbiddenBitmask S
 
 	for _, id := range reqIds {
 		st, ok := m.statesMap[id]
@@ -373,7 +752,7 @@ func (m *Multistate) GetMultistatesByRequiredAndForbiddenStateIds(reqIds, forbId
 			return nil, fmt.Errorf("state id '%s': %w", id, ErrInvalidState)
 		}
 
-		requiredBitmask |= 1 << st.bit
+		requiredBitmask = m.ops.or(requiredBitmask, m.ops.flag(st.bit))
 	}
 
 	for _, id := range forbIds {
@@ -382,32 +761,48 @@ func (m *Multistate) GetMultistatesByRequiredAndForbiddenStateIds(reqIds, forbId
 			return nil, fmt.Errorf("state id '%s': %w", id, ErrInvalidState)
 		}
 
-		forbiddenBitmask |= 1 << st.bit
+		forbiddenBitmask = m.ops.or(forbiddenBitmask, m.ops.flag(st.bit))
 	}
 
-	var ret []uint64
+	var ret []S
 
 	for multistate := range m.statesActions {
-		if multistate&requiredBitmask == requiredBitmask && multistate&forbiddenBitmask == 0 {
+		if m.ops.and(multistate, requiredBitmask) == requiredBitmask && m.ops.isZero(m.ops.and(multistate, forbiddenBitmask)) {
 			ret = append(ret, multistate)
 		}
 	}
-	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	m.sortStates(ret)
 
 	return ret, nil
 }
 
-type Connection struct {
-	From   uint64
-	To     uint64
+type ConnectionOf[S StateValue] struct {
+	From   S
+	To     S
 	Action string
 }
 
-func (m *Multistate) GetConnections() []Connection {
-	var res []Connection
+type Connection = ConnectionOf[uint64]
+
+// GetConnections returns nil in the lazy mode, use Connections to tell it
+// from no transitions.
+func (m *machine[S]) GetConnections() []ConnectionOf[S] {
+	res, _ := m.Connections()
+
+	return res
+}
+
+// Connections returns the transitions of the compiled graph. It fails with
+// ErrLazyMode in the lazy mode.
+func (m *machine[S]) Connections() ([]ConnectionOf[S], error) {
+	if m.IsLazy() {
+		return nil, ErrLazyMode
+	}
+
+	var res []ConnectionOf[S]
 	for from, actions := range m.statesActions {
 		for action, to := range actions {
-			c := Connection{
+			c := ConnectionOf[S]{
 				From:   from,
 				To:     to,
 				Action: action,
@@ -417,12 +812,16 @@ func (m *Multistate) GetConnections() []Connection {
 	}
 	sort.Slice(res, func(i, j int) bool {
 		if res[i].From != res[j].From {
-			return res[i].From < res[j].From
+			return m.ops.less(res[i].From, res[j].From)
 		}
 		if res[i].To != res[j].To {
-			return res[i].To < res[j].To
+			return m.ops.less(res[i].To, res[j].To)
 		}
 		return res[i].Action < res[j].Action
 	})
-	return res
+	return res, nil
+}
+
+func (m *machine[S]) sortStates(states []S) {
+	sort.Slice(states, func(i, j int) bool { return m.ops.less(states[i], states[j]) })
 }
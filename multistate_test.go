@@ -2,12 +2,20 @@ package multistate_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/go-qbit/multistate"
+	"github.com/go-qbit/multistate/bitset"
 	. "github.com/go-qbit/multistate/expr"
+	"github.com/go-qbit/multistate/history"
 )
 
 type testEntity struct {
@@ -373,3 +381,1017 @@ func TestMultistate_GetMultistatesByRequiredAndForbiddenStateIds_ErrorInvalidFor
 	assert.Equal(t, []uint64(nil), msts)
 	assert.ErrorIs(t, err, multistate.ErrInvalidState)
 }
+
+func TestMultistate_DoAction_InvalidActionMessage(t *testing.T) {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B")
+
+	mst.MustAddAction("sign_a", "Sign A", Empty(), multistate.States{signedA}, nil, nil, nil)
+	mst.MustAddAction("sign_b", "Sign B", MustParse("signed_a & !signed_b", mst.ResolveState), multistate.States{signedB}, nil, nil, nil)
+	mst.MustCompile()
+
+	_, err := mst.DoAction(context.Background(), &testEntity{}, "sign_b")
+	assert.ErrorIs(t, err, multistate.ErrInvalidAction)
+	assert.EqualError(t, err, "action 'sign_b' requires 'signed_a & !signed_b', current state 0: invalid_action_error")
+}
+
+func newIndependentFlagsMultistate(flags int) *multistate.Multistate {
+	mst := multistate.New("New")
+
+	for i := 0; i < flags; i++ {
+		st := mst.MustAddState(uint8(i), fmt.Sprintf("flag_%d", i), fmt.Sprintf("Flag %d", i))
+		mst.MustAddAction(fmt.Sprintf("set_%d", i), fmt.Sprintf("Set %d", i), Not(st), multistate.States{st}, nil, nil, nil)
+	}
+
+	return mst
+}
+
+func TestMultistate_Compile_Stats(t *testing.T) {
+	mst := newIndependentFlagsMultistate(12)
+
+	require.NoError(t, mst.Compile())
+
+	stats := mst.GetCompileStats()
+	assert.Equal(t, 4096, stats.States)
+	assert.Equal(t, 12*2048, stats.Transitions)
+	assert.Len(t, mst.GetConnections(), 12*2048)
+}
+
+func TestMultistate_Compile_WithMaxStates(t *testing.T) {
+	mst := newIndependentFlagsMultistate(12)
+
+	err := mst.Compile(multistate.WithMaxStates(1000))
+	assert.ErrorIs(t, err, multistate.ErrTooManyStates)
+	assert.Contains(t, err.Error(), "more than 1000 states are reachable")
+
+	assert.NoError(t, mst.Compile(multistate.WithMaxStates(4096)))
+}
+
+func TestMultistate_LazyResolution(t *testing.T) {
+	mst := newIndependentFlagsMultistate(48)
+
+	require.NoError(t, mst.Compile(multistate.WithLazyResolution(2)))
+	assert.True(t, mst.IsLazy())
+	assert.Error(t, mst.Compile())
+
+	e := &testEntity{}
+	for _, action := range []string{"set_0", "set_47", "set_20"} {
+		_, err := mst.DoAction(context.Background(), e, action)
+		require.NoError(t, err, action)
+	}
+	assert.Equal(t, uint64(1|1<<47|1<<20), e.state)
+
+	_, err := mst.DoAction(context.Background(), e, "set_47")
+	assert.ErrorIs(t, err, multistate.ErrInvalidAction)
+
+	assert.Len(t, mst.GetStateActions(context.Background(), e.state), 45)
+	assert.Nil(t, mst.GetStateActions(context.Background(), 1<<50))
+
+	e.state = 1 << 50
+	_, err = mst.DoAction(context.Background(), e, "set_0")
+	assert.ErrorIs(t, err, multistate.ErrInvalidState)
+
+	assert.Nil(t, mst.GetConnections())
+	assert.Nil(t, mst.GetStatesByActions("set_0"))
+	assert.Nil(t, mst.GetMultistatesByStateIds("flag_0"))
+	_, err = mst.Connections()
+	assert.ErrorIs(t, err, multistate.ErrLazyMode)
+	_, err = mst.StatesByActions("set_0")
+	assert.ErrorIs(t, err, multistate.ErrLazyMode)
+	_, err = mst.MultistatesByStateIds("flag_0")
+	assert.ErrorIs(t, err, multistate.ErrLazyMode)
+	def, err := mst.Export()
+	require.NoError(t, err)
+	assert.Empty(t, def.Transitions)
+	_, err = mst.GetMultistatesByRequiredAndForbiddenStateIds([]string{"flag_0"}, nil)
+	assert.ErrorIs(t, err, multistate.ErrLazyMode)
+	assert.ErrorIs(t, mst.WriteDOT(io.Discard), multistate.ErrLazyMode)
+}
+
+type testCASEntity struct {
+	testEntity
+	beforeCAS []func(e *testCASEntity)
+}
+
+func (e *testCASEntity) CompareAndSetState(_ context.Context, expected, newState uint64) (bool, error) {
+	if len(e.beforeCAS) > 0 {
+		e.beforeCAS[0](e)
+		e.beforeCAS = e.beforeCAS[1:]
+	}
+	if e.state != expected {
+		return false, nil
+	}
+	e.state = newState
+	return true, nil
+}
+
+func TestMultistate_DoAction_CompareAndSet(t *testing.T) {
+	mst := newIndependentFlagsMultistate(3)
+	mst.MustCompile()
+
+	concurrentSet1 := func(e *testCASEntity) { e.state |= 2 }
+
+	e := &testCASEntity{beforeCAS: []func(*testCASEntity){concurrentSet1}}
+	_, err := mst.DoAction(context.Background(), e, "set_0")
+	assert.ErrorIs(t, err, multistate.ErrStateConflict)
+	assert.Equal(t, uint64(2), e.state)
+
+	mst.SetConflictRetries(1)
+
+	e = &testCASEntity{beforeCAS: []func(*testCASEntity){concurrentSet1}}
+	newState, err := mst.DoAction(context.Background(), e, "set_0")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), newState)
+	assert.Equal(t, uint64(3), e.state)
+
+	e = &testCASEntity{beforeCAS: []func(*testCASEntity){func(e *testCASEntity) { e.state |= 1 }}}
+	_, err = mst.DoAction(context.Background(), e, "set_0")
+	assert.ErrorIs(t, err, multistate.ErrInvalidAction)
+	assert.Equal(t, uint64(1), e.state)
+}
+
+func TestMultistate_DoAction_CompareAndSetRestart(t *testing.T) {
+	mst := multistate.New("New")
+
+	a := mst.MustAddState(0, "a", "A")
+	b := mst.MustAddState(1, "b", "B")
+
+	var log []string
+	mst.SetOnDoCallback(func(_ context.Context, _ multistate.Entity, prevState, newState uint64, action string, _ ...interface{}) error {
+		log = append(log, fmt.Sprintf("do %s %d->%d", action, prevState, newState))
+		return nil
+	})
+	mst.MustAddAction("set_a", "Set A", Not(a), multistate.States{a}, nil, nil, nil)
+	mst.MustAddAction("set_b", "Set B", Not(b), multistate.States{b}, nil, nil, nil)
+	mst.MustAddAction("guarded_a", "Guarded A", And(Not(a), Not(b)), multistate.States{a}, nil, nil, nil)
+	mst.MustCompile()
+	mst.SetConflictRetries(1)
+	require.NoError(t, mst.OnEnter(a, func(_ context.Context, _ multistate.Entity, prevState, newState uint64, action string) error {
+		log = append(log, fmt.Sprintf("enter a %d->%d", prevState, newState))
+		return nil
+	}))
+
+	concurrentSetB := func(e *testCASEntity) { e.state |= 2 }
+
+	e := &testCASEntity{beforeCAS: []func(*testCASEntity){concurrentSetB}}
+	_, err := mst.DoAction(context.Background(), e, "set_a")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), e.state)
+	assert.Equal(t, []string{"do set_a 0->1", "do set_a 2->3", "enter a 2->3"}, log)
+
+	log = nil
+	e = &testCASEntity{beforeCAS: []func(*testCASEntity){concurrentSetB}}
+	_, err = mst.DoAction(context.Background(), e, "guarded_a")
+	assert.ErrorIs(t, err, multistate.ErrInvalidAction)
+	assert.Equal(t, uint64(2), e.state)
+	assert.Equal(t, []string{"do guarded_a 0->1"}, log)
+}
+
+func TestMultistate_DoAction_ActionError(t *testing.T) {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B")
+
+	errFailed := errors.New("failed")
+	mst.MustAddAction("sign_a", "Sign A", Empty(), multistate.States{signedA}, nil, nil, nil)
+	mst.MustAddAction("sign_b", "Sign B", signedA, multistate.States{signedB}, nil,
+		func(context.Context, multistate.Entity, ...interface{}) error { return errFailed }, nil)
+	mst.MustCompile()
+
+	e := &testEntity{}
+	_, err := mst.DoAction(context.Background(), e, "sign_b")
+
+	var actionErr *multistate.ActionError
+	require.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, multistate.ActionError{
+		Action:    "sign_b",
+		EntityId:  1,
+		FromState: 0,
+		Phase:     multistate.PhaseValidate,
+		Cause:     actionErr.Cause,
+	}, *actionErr)
+	assert.ErrorIs(t, err, multistate.ErrInvalidAction)
+
+	_, err = mst.DoAction(context.Background(), e, "sign_a")
+	require.NoError(t, err)
+
+	_, err = mst.DoAction(context.Background(), e, "sign_b")
+	require.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, multistate.PhaseDo, actionErr.Phase)
+	assert.Equal(t, uint64(1), actionErr.FromState)
+	assert.Equal(t, uint64(3), actionErr.ToState)
+	assert.ErrorIs(t, err, multistate.ErrExecutionAction)
+	assert.ErrorIs(t, err, errFailed)
+	assert.EqualError(t, err, "execute_action_error: failed")
+}
+
+type ownerAvailabler struct {
+	owner interface{}
+}
+
+func (ownerAvailabler) String() string                   { return "owner" }
+func (ownerAvailabler) IsAvailable(context.Context) bool { return true }
+func (a ownerAvailabler) IsAvailableFor(_ context.Context, entity multistate.Entity, _ uint64) bool {
+	return entity.GetId() == a.owner
+}
+
+func TestMultistate_EntityAvailabler(t *testing.T) {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B")
+
+	mst.MustAddAction("sign_a", "Sign A", Not(signedA), multistate.States{signedA}, nil, nil, ownerAvailabler{2})
+	mst.MustAddAction("sign_b", "Sign B", Not(signedB), multistate.States{signedB}, nil, nil, nil)
+	mst.MustCompile()
+
+	e := &testEntity{}
+
+	assert.Equal(t, []string{"sign_a", "sign_b"}, mst.GetStateActions(context.Background(), 0))
+
+	actions, err := mst.GetEntityActions(context.Background(), e)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sign_b"}, actions)
+
+	_, err = mst.DoAction(context.Background(), e, "sign_a")
+	assert.ErrorIs(t, err, multistate.ErrNotAvailable)
+
+	_, err = mst.DoAction(context.Background(), e, "sign_b")
+	assert.NoError(t, err)
+}
+
+type roleKey struct{}
+
+type roleAvailabler struct {
+	role string
+}
+
+func (a roleAvailabler) String() string                   { return "role_" + a.role }
+func (a roleAvailabler) IsAvailable(context.Context) bool { return false }
+func (a roleAvailabler) UnavailableReasons(ctx context.Context, _ multistate.Entity, _ uint64) []multistate.Reason {
+	if ctx.Value(roleKey{}) == a.role {
+		return nil
+	}
+	return []multistate.Reason{{Code: "role", Message: "requires " + a.role + " role"}}
+}
+
+func TestMultistate_GetStateActionsDetailed(t *testing.T) {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B")
+	signedC := mst.MustAddState(2, "signed_c", "Signed C")
+
+	mst.MustAddAction("sign_a", "Sign A", Not(signedA), multistate.States{signedA}, nil, nil, roleAvailabler{"manager"})
+	mst.MustAddAction("sign_b", "Sign B", Not(signedB), multistate.States{signedB}, nil, nil, testAvailabler{"closed", false})
+	mst.MustAddAction("sign_c", "Sign C", Not(signedC), multistate.States{signedC}, nil, nil, nil)
+	mst.MustCompile()
+
+	assert.Equal(t, []multistate.ActionAvailability{
+		{Action: "sign_a", Caption: "Sign A", NewState: 1, Reasons: []multistate.Reason{{Code: "role", Message: "requires manager role"}}},
+		{Action: "sign_b", Caption: "Sign B", NewState: 2, Reasons: []multistate.Reason{{Code: "closed"}}},
+		{Action: "sign_c", Caption: "Sign C", NewState: 4, Available: true},
+	}, mst.GetStateActionsDetailed(context.Background(), 0))
+
+	managerCtx := context.WithValue(context.Background(), roleKey{}, "manager")
+	assert.Equal(t, []string{"sign_a", "sign_c"}, mst.GetStateActions(managerCtx, 0))
+
+	_, err := mst.DoAction(context.Background(), &testEntity{}, "sign_a")
+	assert.ErrorIs(t, err, multistate.ErrNotAvailable)
+	assert.EqualError(t, err, "action 'sign_a', current state 0: action_not_available_error: requires manager role")
+
+	var unavailableErr *multistate.UnavailableError
+	require.ErrorAs(t, err, &unavailableErr)
+	assert.Equal(t, []multistate.Reason{{Code: "role", Message: "requires manager role"}}, unavailableErr.Reasons)
+
+	_, err = mst.DoAction(managerCtx, &testEntity{}, "sign_a")
+	assert.NoError(t, err)
+}
+
+func TestMultistate_Use(t *testing.T) {
+	mst := newIndependentFlagsMultistate(2)
+
+	var log []string
+	mst.SetOnDoCallback(func(_ context.Context, _ multistate.Entity, prevState, newState uint64, action string, opts ...interface{}) error {
+		log = append(log, fmt.Sprintf("onDo %s %d->%d %v", action, prevState, newState, opts))
+		return nil
+	})
+
+	errDenied := errors.New("denied")
+	mst.Use(
+		func(next multistate.ActionHandler) multistate.ActionHandler {
+			return func(ctx context.Context, call *multistate.ActionCall) error {
+				log = append(log, "outer before "+call.Action)
+				err := next(ctx, call)
+				log = append(log, fmt.Sprintf("outer after %d->%d %v", call.FromState, call.ToState, err))
+				return err
+			}
+		},
+		func(next multistate.ActionHandler) multistate.ActionHandler {
+			return func(ctx context.Context, call *multistate.ActionCall) error {
+				if call.Action == "set_1" {
+					return errDenied
+				}
+				call.Opts = append(call.Opts, "traced")
+				return next(ctx, call)
+			}
+		},
+	)
+	mst.MustCompile()
+
+	e := &testEntity{}
+	_, err := mst.DoAction(context.Background(), e, "set_0", "opt")
+	require.NoError(t, err)
+
+	_, err = mst.DoAction(context.Background(), e, "set_1")
+	assert.ErrorIs(t, err, errDenied)
+	var actionErr *multistate.ActionError
+	require.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, multistate.PhaseMiddleware, actionErr.Phase)
+	assert.Equal(t, uint64(1), e.state)
+
+	assert.Equal(t, []string{
+		"outer before set_0",
+		"onDo set_0 0->1 [opt traced]",
+		"outer after 0->1 <nil>",
+		"outer before set_1",
+		"outer after 1->3 denied",
+	}, log)
+}
+
+type failingEndEntity struct {
+	testEntity
+}
+
+func (*failingEndEntity) EndAction(context.Context, error) error {
+	return errors.New("commit failed")
+}
+
+func TestMultistate_OnTransitionCommitted(t *testing.T) {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B")
+
+	mst.MustAddAction("sign_a", "Sign A", Not(signedA), multistate.States{signedA}, nil, nil, nil)
+	mst.MustAddAction("sign_b", "Sign B", signedA, multistate.States{signedB}, multistate.States{signedA}, nil, nil)
+	mst.MustCompile()
+
+	var events []multistate.TransitionEvent
+	mst.OnTransitionCommitted(func(_ context.Context, event multistate.TransitionEvent) {
+		events = append(events, event)
+	})
+
+	ch, unsubscribe := mst.MustSubscribe(1)
+
+	_, err := mst.DoAction(context.Background(), &failingEndEntity{}, "sign_a")
+	require.Error(t, err)
+	assert.Empty(t, events)
+
+	e := &testEntity{}
+	_, err = mst.DoAction(context.Background(), e, "sign_a")
+	require.NoError(t, err)
+	assert.Equal(t, "sign_a", (<-ch).Action)
+
+	_, _, err = mst.Subscribe(0)
+	assert.EqualError(t, err, "invalid buffer size 0, the events would always be dropped")
+
+	slow, unsubscribeSlow := mst.MustSubscribe(1)
+	defer unsubscribeSlow()
+
+	_, err = mst.DoAction(context.Background(), &testEntity{}, "sign_a")
+	require.NoError(t, err)
+	_, err = mst.DoAction(context.Background(), &testEntity{}, "sign_a")
+	require.NoError(t, err)
+	assert.Equal(t, "sign_a", (<-ch).Action)
+	assert.Len(t, slow, 1)
+	assert.Equal(t, uint64(2), mst.DroppedEvents())
+
+	unsubscribe()
+	unsubscribe()
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	_, err = mst.DoAction(context.Background(), e, "sign_b")
+	require.NoError(t, err)
+
+	require.Len(t, events, 4)
+	last := events[3]
+	assert.False(t, last.Time.IsZero())
+	last.Time = time.Time{}
+	assert.Equal(t, multistate.TransitionEvent{
+		EntityId:   1,
+		Action:     "sign_b",
+		FromState:  1,
+		ToState:    2,
+		SetFlags:   []multistate.StateFlag{{Id: "signed_b", Bit: 1, Caption: "Signed B"}},
+		ResetFlags: []multistate.StateFlag{{Id: "signed_a", Bit: 0, Caption: "Signed A"}},
+	}, last)
+}
+
+func TestMultistate_OnEnterOnExit(t *testing.T) {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B")
+	signedC := mst.MustAddState(2, "signed_c", "Signed C")
+
+	mst.MustAddAction("sign_a", "Sign A", Empty(), multistate.States{signedA}, nil, nil, nil)
+	mst.MustAddAction("sign_b", "Sign B", Not(signedB), multistate.States{signedB}, nil, nil, nil)
+	mst.MustAddAction("sign_c", "Sign C", Or(signedA, signedB), multistate.States{signedC}, multistate.States{signedA, signedB}, nil, nil)
+	mst.MustCompile()
+
+	var log []string
+	hook := func(name string) multistate.StateChangeFunc {
+		return func(_ context.Context, _ multistate.Entity, prevState, newState uint64, action string) error {
+			log = append(log, fmt.Sprintf("%s %s %d->%d", name, action, prevState, newState))
+			return nil
+		}
+	}
+	require.NoError(t, mst.OnEnter(signedC, hook("enter C")))
+	require.NoError(t, mst.OnExit(signedA, hook("exit A")))
+	require.NoError(t, mst.OnExit(signedB, hook("exit B")))
+
+	other := multistate.New("")
+	assert.Error(t, mst.OnEnter(other.MustAddState(3, "signed_d", "Signed D"), hook("enter D")))
+
+	e := &testEntity{}
+	for _, action := range []string{"sign_a", "sign_c", "sign_b", "sign_c"} {
+		_, err := mst.DoAction(context.Background(), e, action)
+		require.NoError(t, err, action)
+	}
+
+	assert.Equal(t, []string{
+		"exit A sign_c 1->4",
+		"enter C sign_c 1->4",
+		"exit B sign_c 6->4",
+	}, log)
+
+	errFailed := errors.New("failed")
+	require.NoError(t, mst.OnEnter(signedA, func(context.Context, multistate.Entity, uint64, uint64, string) error { return errFailed }))
+
+	e = &testEntity{}
+	_, err := mst.DoAction(context.Background(), e, "sign_a")
+	var actionErr *multistate.ActionError
+	require.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, multistate.PhaseEnter, actionErr.Phase)
+	assert.ErrorIs(t, err, errFailed)
+	// The hooks run after SetState, rolling it back is up to EndAction
+	assert.Equal(t, uint64(1), e.state)
+}
+
+func TestMultistate_PlanPath(t *testing.T) {
+	mst := multistate.New("New")
+
+	signedA := mst.MustAddState(0, "signed_a", "Signed A")
+	signedB := mst.MustAddState(1, "signed_b", "Signed B")
+	active := mst.MustAddState(2, "active", "Active")
+
+	mst.MustAddAction("sign_a", "Sign A", Not(signedA), multistate.States{signedA}, nil, nil, nil)
+	mst.MustAddAction("sign_b", "Sign B", Not(signedB), multistate.States{signedB}, nil, nil, nil)
+	mst.MustAddAction("sign_both", "Sign both", Empty(), multistate.States{signedA, signedB}, nil, nil, roleAvailabler{"manager"})
+	mst.MustAddAction("activate", "Activate", And(signedA, signedB, Not(active)), multistate.States{active}, nil, nil, nil)
+	mst.MustCompile()
+
+	path, err := mst.PlanPath(0, active)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sign_both", "activate"}, path)
+
+	path, err = mst.PlanPath(0, active, multistate.WithAvailableActions(context.Background(), nil))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sign_a", "sign_b", "activate"}, path)
+
+	paths, err := mst.PlanAllPaths(0, active, multistate.WithAvailableActions(context.Background(), nil))
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"sign_a", "sign_b", "activate"}, {"sign_b", "sign_a", "activate"}}, paths)
+
+	paths, err = mst.PlanAllPaths(0, And(signedA, signedB))
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"sign_both"}}, paths)
+
+	path, err = mst.PlanPath(7, active)
+	require.NoError(t, err)
+	assert.Empty(t, path)
+
+	_, err = mst.PlanPath(7, Empty())
+	assert.ErrorIs(t, err, multistate.ErrNoPath)
+
+	_, err = mst.PlanPath(8, active)
+	assert.ErrorIs(t, err, multistate.ErrInvalidState)
+}
+
+func TestMultistate_Analyze(t *testing.T) {
+	mst := multistate.New("New")
+
+	signed := mst.MustAddState(0, "signed", "Signed")
+	active := mst.MustAddState(1, "active", "Active")
+	rejected := mst.MustAddState(2, "rejected", "Rejected")
+	archived := mst.MustAddState(3, "archived", "Archived")
+
+	mst.MustAddAction("sign", "Sign", Empty(), multistate.States{signed}, nil, nil, nil)
+	mst.MustAddAction("unsign", "Unsign", And(signed, Not(active)), nil, multistate.States{signed}, nil, nil)
+	mst.MustAddAction("activate", "Activate", And(signed, Not(active)), multistate.States{active}, nil, nil, nil)
+	mst.MustAddAction("reject", "Reject", Empty(), multistate.States{rejected}, nil, nil, nil)
+	mst.MustAddAction("archive", "Archive", And(active, rejected), multistate.States{archived}, nil, nil, nil)
+	mst.MustCompile()
+
+	report, err := mst.Analyze(active)
+	require.NoError(t, err)
+
+	assert.Equal(t, multistate.Report{
+		DeadActions:    []string{"archive"},
+		UnsetFlags:     []string{"archived"},
+		TerminalStates: []uint64{3, 4},
+		Components:     [][]uint64{{0, 1}, {3}, {4}},
+		TrapStates:     []uint64{4},
+	}, report)
+
+	report, err = mst.Analyze(nil)
+	require.NoError(t, err)
+	assert.Empty(t, report.TrapStates)
+
+	lazy := newIndependentFlagsMultistate(3)
+	require.NoError(t, lazy.Compile(multistate.WithLazyResolution(0)))
+	_, err = lazy.Analyze(nil)
+	assert.ErrorIs(t, err, multistate.ErrLazyMode)
+}
+
+type testBitsetEntity struct {
+	state bitset.Bitset
+}
+
+func (*testBitsetEntity) StartAction(ctx context.Context) (context.Context, error) { return ctx, nil }
+func (e *testBitsetEntity) GetState(context.Context) (bitset.Bitset, error)        { return e.state, nil }
+func (e *testBitsetEntity) SetState(_ context.Context, s bitset.Bitset, _ ...interface{}) error {
+	e.state = s
+	return nil
+}
+func (*testBitsetEntity) EndAction(_ context.Context, err error) error { return err }
+func (*testBitsetEntity) GetId() interface{}                           { return 1 }
+
+func TestBitsetMultistate(t *testing.T) {
+	mst := multistate.NewBitset("New")
+
+	checks := make([]Expression, 80)
+	for i := range checks {
+		st := mst.MustAddState(uint8(i), fmt.Sprintf("check_%d", i), fmt.Sprintf("Check %d", i))
+		mst.MustAddAction(fmt.Sprintf("check_%d", i), fmt.Sprintf("Check %d", i), Not(st), multistate.States{st}, nil, nil, nil)
+		checks[i] = st
+	}
+	onboarded := mst.MustAddState(200, "onboarded", "Onboarded")
+	mst.MustAddAction("onboard", "Onboard", And(checks[0], checks[1], checks[2:]...), multistate.States{onboarded}, nil, nil, nil)
+
+	e := &testBitsetEntity{}
+	_, err := mst.DoAction(context.Background(), e, "onboard")
+	assert.ErrorIs(t, err, multistate.ErrInvalidAction)
+
+	for i := range checks {
+		_, err := mst.DoAction(context.Background(), e, fmt.Sprintf("check_%d", i))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"onboard"}, mst.GetStateActions(context.Background(), e.state))
+
+	newState, err := mst.DoAction(context.Background(), e, "onboard")
+	require.NoError(t, err)
+	assert.True(t, newState.Has(200))
+	assert.Len(t, mst.GetStateFlags(newState), 81)
+	assert.Equal(t, "Check 79.\nOnboarded.", mst.GetStateName(bitset.Of(79, 200)))
+	assert.Equal(t, "New", mst.GetStateName(bitset.Bitset{}))
+}
+
+type bitsetOwnerAvailabler struct {
+	owner interface{}
+}
+
+func (bitsetOwnerAvailabler) String() string                   { return "owner" }
+func (bitsetOwnerAvailabler) IsAvailable(context.Context) bool { return true }
+func (a bitsetOwnerAvailabler) IsAvailableFor(_ context.Context, entity multistate.BitsetEntity, _ bitset.Bitset) bool {
+	return entity.GetId() == a.owner
+}
+
+type testBitsetCASEntity struct {
+	testBitsetEntity
+	conflict bool
+}
+
+func (e *testBitsetCASEntity) CompareAndSetState(_ context.Context, expected, newState bitset.Bitset) (bool, error) {
+	if e.conflict || e.state != expected {
+		return false, nil
+	}
+	e.state = newState
+
+	return true, nil
+}
+
+func TestBitsetMultistate_DoAction(t *testing.T) {
+	mst := multistate.NewBitset("New")
+
+	opened := mst.MustAddState(0, "opened", "Opened")
+	reviewed := mst.MustAddState(100, "reviewed", "Reviewed")
+	closed := mst.MustAddState(200, "closed", "Closed")
+
+	var log []string
+	mst.MustAddAction("open", "Open", Empty(), multistate.States{opened}, nil, nil, nil)
+	mst.MustAddAction("review", "Review", And(opened, Not(reviewed)), multistate.States{reviewed}, nil,
+		func(context.Context, multistate.BitsetEntity, ...interface{}) error {
+			log = append(log, "do review")
+			return nil
+		}, nil)
+	mst.MustAddAction("close", "Close", reviewed, multistate.States{closed}, multistate.States{opened}, nil, bitsetOwnerAvailabler{1})
+	mst.MustSetCompensation("review", func(context.Context, multistate.BitsetEntity, ...interface{}) error {
+		log = append(log, "compensate review")
+		return nil
+	})
+
+	mst.SetOnDoCallback(func(_ context.Context, _ multistate.BitsetEntity, prevState, newState bitset.Bitset, action string, _ ...interface{}) error {
+		log = append(log, fmt.Sprintf("onDo %s %s->%s", action, prevState, newState))
+		return nil
+	})
+	mst.Use(func(next multistate.ActionHandlerOf[bitset.Bitset]) multistate.ActionHandlerOf[bitset.Bitset] {
+		return func(ctx context.Context, call *multistate.ActionCallOf[bitset.Bitset]) error {
+			log = append(log, "middleware "+call.Action)
+			return next(ctx, call)
+		}
+	})
+	require.NoError(t, mst.OnEnter(closed, func(_ context.Context, _ multistate.BitsetEntity, _, _ bitset.Bitset, action string) error {
+		log = append(log, "enter closed by "+action)
+		return nil
+	}))
+
+	var events []multistate.TransitionEventOf[bitset.Bitset]
+	mst.OnTransitionCommitted(func(_ context.Context, event multistate.TransitionEventOf[bitset.Bitset]) {
+		events = append(events, event)
+	})
+
+	store := history.NewMemoryStoreOf[bitset.Bitset]()
+	mst.SetHistoryStore(store, true)
+
+	e := &testBitsetEntity{}
+	_, err := mst.DoAction(context.Background(), e, "close")
+	var actionErr *multistate.ActionErrorOf[bitset.Bitset]
+	require.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, multistate.PhaseValidate, actionErr.Phase)
+	assert.EqualError(t, err, "action 'close' requires 'reviewed', current state 0x0: invalid_action_error")
+
+	for _, action := range []string{"open", "review"} {
+		_, err := mst.DoAction(context.Background(), e, action)
+		require.NoError(t, err, action)
+	}
+
+	_, err = mst.DoAction(context.Background(), &testBitsetCASEntity{testBitsetEntity: *e, conflict: true}, "close")
+	assert.ErrorIs(t, err, multistate.ErrStateConflict)
+	assert.Equal(t, []string{"close"}, mst.GetStateActions(context.Background(), e.state))
+
+	newState, err := mst.DoAction(context.Background(), e, "close")
+	require.NoError(t, err)
+	assert.Equal(t, bitset.Of(100, 200), newState)
+
+	actions, err := mst.GetEntityActions(context.Background(), &testBitsetCASEntity{testBitsetEntity: testBitsetEntity{bitset.Of(100)}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"close"}, actions)
+
+	e.state = bitset.Of(0)
+	_, err = mst.DoAction(context.Background(), &testBitsetCASEntity{testBitsetEntity: *e, conflict: true}, "review")
+	require.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, multistate.PhaseSetState, actionErr.Phase)
+	assert.Equal(t, bitset.Of(0, 100), actionErr.ToState)
+
+	assert.Equal(t, []string{
+		"middleware open",
+		"onDo open 0x0->0x1",
+		"middleware review",
+		"onDo review 0x1->0x10000000000000000000000001",
+		"do review",
+		"middleware close",
+		"onDo close 0x10000000000000000000000001->0x100000000000000000000000010000000000000000000000000",
+		"middleware close",
+		"onDo close 0x10000000000000000000000001->0x100000000000000000000000010000000000000000000000000",
+		"enter closed by close",
+		"middleware review",
+		"onDo review 0x1->0x10000000000000000000000001",
+		"do review",
+		"compensate review",
+	}, log)
+
+	require.Len(t, events, 3)
+	assert.Equal(t, []multistate.StateFlag{{Id: "closed", Bit: 200, Caption: "Closed"}}, events[2].SetFlags)
+	assert.Equal(t, []multistate.StateFlag{{Id: "opened", Bit: 0, Caption: "Opened"}}, events[2].ResetFlags)
+
+	entries, err := mst.GetHistory(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, entries, 6)
+	assert.Equal(t, "close", entries[4].Action)
+	assert.Equal(t, []multistate.StateFlag{{Id: "reviewed", Bit: 100, Caption: "Reviewed"}, {Id: "closed", Bit: 200, Caption: "Closed"}}, entries[4].NewFlags)
+	assert.NotEmpty(t, entries[5].Error)
+}
+
+func TestBitsetMultistate_Compile(t *testing.T) {
+	mst := multistate.NewBitset("New")
+
+	a := mst.MustAddState(0, "a", "A")
+	b := mst.MustAddState(70, "b", "B")
+	mst.MustAddAction("set_a", "Set A", Not(a), multistate.States{a}, nil, nil, nil)
+	mst.MustAddAction("set_b", "Set B", And(a, Not(b)), multistate.States{b}, nil, nil, nil)
+	mst.AddCluster("With B", b)
+
+	assert.True(t, mst.IsLazy())
+	assert.ErrorIs(t, mst.WriteMermaid(io.Discard), multistate.ErrLazyMode)
+
+	require.NoError(t, mst.Compile())
+	assert.False(t, mst.IsLazy())
+	assert.Equal(t, 3, mst.GetCompileStats().States)
+	assert.Equal(t, []multistate.ConnectionOf[bitset.Bitset]{
+		{From: bitset.Bitset{}, To: bitset.Of(0), Action: "set_a"},
+		{From: bitset.Of(0), To: bitset.Of(0, 70), Action: "set_b"},
+	}, mst.GetConnections())
+	assert.Equal(t, []bitset.Bitset{bitset.Of(0, 70)}, mst.GetMultistatesByStateIds("b"))
+
+	buf := &strings.Builder{}
+	require.NoError(t, mst.WriteMermaid(buf))
+	assert.Equal(t, `stateDiagram-v2
+    state "New" as s0x0
+    state "A." as s0x1
+    state "With B" as cluster_0 {
+        state "A.<br/>B." as s0x400000000000000001
+    }
+    [*] --> s0x0
+    s0x0 --> s0x1 : Set A
+    s0x1 --> s0x400000000000000001 : Set B
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, mst.WriteSVG(buf))
+	assert.Contains(t, buf.String(), `id="state-0x400000000000000001"`)
+}
+
+type bitsetImpl struct {
+	Draft    multistate.State `bit:"0"`
+	Approved multistate.State `bit:"150" caption:"Approved by all"`
+}
+
+func (i *bitsetImpl) ActionApprove() multistate.BitsetAction {
+	return multistate.BitsetAction{
+		From: Not(i.Approved),
+		Set:  multistate.States{i.Approved},
+	}
+}
+
+func TestNewBitsetFromStruct(t *testing.T) {
+	mst := multistate.NewBitsetFromStruct(&bitsetImpl{})
+
+	e := &testBitsetEntity{}
+	newState, err := mst.DoAction(context.Background(), e, "approve")
+	require.NoError(t, err)
+	assert.Equal(t, bitset.Of(150), newState)
+	assert.Equal(t, "Approved by all.", mst.GetStateName(newState))
+	assert.Equal(t, "Approve", mst.GetActionName("approve"))
+
+	assert.PanicsWithValue(t, "The action method ActionTest must return the multistate.BitsetAction structure", func() {
+		multistate.NewBitsetFromStruct(&ExampleImpl{})
+	})
+}
+
+func TestMultistate_Enum(t *testing.T) {
+	mst := multistate.New("New")
+
+	signed := mst.MustAddState(0, "signed", "Signed")
+	risk := mst.MustAddEnum(1, 2, "risk", "Risk", "low", "medium", "high")
+
+	mst.MustAddAction("assess_low", "Assess low", Not(signed), multistate.States{risk.Set("low")}, nil, nil, nil)
+	mst.MustAddAction("escalate", "Escalate", Or(risk.Is("low"), risk.Is("medium")), multistate.States{risk.Set("high")}, nil, nil, nil)
+	mst.MustAddAction("sign", "Sign", And(Not(signed), Not(risk.Is("high"))), multistate.States{signed}, nil, nil, nil)
+	require.NoError(t, mst.Compile())
+
+	assert.Equal(t, "risk=low.", mst.GetStateName(2))
+	assert.Equal(t, "Signed.\nrisk=high.", mst.GetStateName(7))
+	assert.Equal(t, []multistate.StateFlag{{Id: "risk=high", Bit: 1, Caption: "risk=high"}}, mst.GetStateFlags(6))
+	assert.Equal(t, "high", risk.Value(6))
+
+	var events []multistate.TransitionEvent
+	mst.OnTransitionCommitted(func(_ context.Context, event multistate.TransitionEvent) {
+		events = append(events, event)
+	})
+
+	e := &testEntity{}
+	for _, action := range []string{"assess_low", "escalate"} {
+		_, err := mst.DoAction(context.Background(), e, action)
+		require.NoError(t, err, action)
+	}
+	assert.Equal(t, uint64(6), e.state)
+
+	require.Len(t, events, 2)
+	assert.Equal(t, []multistate.StateFlag{{Id: "risk=low", Bit: 1, Caption: "risk=low"}}, events[0].SetFlags)
+	assert.Equal(t, []multistate.StateFlag{}, events[0].ResetFlags)
+	assert.Equal(t, []multistate.StateFlag{{Id: "risk=high", Bit: 1, Caption: "risk=high"}}, events[1].SetFlags)
+	assert.Equal(t, []multistate.StateFlag{{Id: "risk=low", Bit: 1, Caption: "risk=low"}}, events[1].ResetFlags)
+
+	_, err := mst.DoAction(context.Background(), e, "sign")
+	assert.EqualError(t, err, "action 'sign' requires '!signed & !risk == high', current state 6: invalid_action_error")
+
+	_, err = mst.AddState(2, "medium", "Medium")
+	assert.EqualError(t, err, "bit '2' already busy")
+
+	err = mst.AddAction("reset", "Reset", Any(), multistate.States{risk.Set("low"), risk.Set("high")}, nil, nil, nil)
+	assert.EqualError(t, err, "enum 'risk' is set twice")
+	err = mst.AddAction("reset", "Reset", Any(), multistate.States{risk}, nil, nil, nil)
+	assert.EqualError(t, err, "enum 'risk' needs a value to be set")
+	err = mst.AddAction("reset", "Reset", Any(), multistate.States{risk.Set("low")}, multistate.States{risk}, nil, nil)
+	assert.EqualError(t, err, "enum 'risk' is set and reset")
+
+	_, err = mst.AddEnum(2, 63, "wide", "Wide", "one")
+	assert.EqualError(t, err, "enum 'wide' must fit in 64 bits")
+	_, err = multistate.New("New").AddEnum(0, 64, "wide", "Wide")
+	assert.EqualError(t, err, "enum 'wide' must have from 1 to 18446744073709551615 values")
+	wide := multistate.New("New").MustAddEnum(1, 63, "wide", "Wide", "one", "two")
+	assert.Equal(t, "two", wide.Value(4))
+
+	lazy := multistate.New("New")
+	level := lazy.MustAddEnum(0, 2, "level", "Level", "one", "two")
+	lazy.MustAddAction("two", "Two", level.Is("one"), multistate.States{level.Set("two")}, nil, nil, nil)
+	require.NoError(t, lazy.Compile(multistate.WithLazyResolution(0)))
+
+	_, err = lazy.DoAction(context.Background(), &testEntity{state: 1}, "two")
+	assert.NoError(t, err)
+	_, err = lazy.DoAction(context.Background(), &testEntity{state: 3}, "two")
+	assert.ErrorIs(t, err, multistate.ErrInvalidState)
+}
+
+func TestMultistate_Automatic(t *testing.T) {
+	mst := multistate.New("New")
+
+	signedD := mst.MustAddState(0, "signed_d", "Signed D")
+	signedE := mst.MustAddState(1, "signed_e", "Signed E")
+	signedF := mst.MustAddState(2, "signed_f", "Signed F")
+	archived := mst.MustAddState(3, "archived", "Archived")
+
+	var fired []string
+	onDo := func(_ context.Context, _ multistate.Entity, prevState, newState uint64, action string, _ ...interface{}) error {
+		fired = append(fired, fmt.Sprintf("%s %d->%d", action, prevState, newState))
+		return nil
+	}
+	mst.SetOnDoCallback(onDo)
+
+	mst.MustAddAction("sign_d", "Sign D", Not(signedD), multistate.States{signedD}, nil, nil, nil)
+	mst.MustAddAction("sign_e", "Sign E", Not(signedE), multistate.States{signedE}, nil, nil, nil)
+	mst.MustAddAction("sign_f", "Sign F", And(signedD, signedE, Not(signedF)), multistate.States{signedF}, nil, nil, nil)
+	mst.MustAddAction("archive", "Archive", And(signedF, Not(archived)), multistate.States{archived}, nil, nil, nil)
+	mst.MustSetAutomatic("sign_f")
+	mst.MustSetAutomatic("archive")
+	require.NoError(t, mst.Compile())
+
+	assert.Equal(t, map[uint64][]string{0: {"sign_d", "sign_e"}, 1: {"sign_e"}, 2: {"sign_d"}, 15: {}}, map[uint64][]string{
+		0:  mst.GetStateActions(context.Background(), 0),
+		1:  mst.GetStateActions(context.Background(), 1),
+		2:  mst.GetStateActions(context.Background(), 2),
+		15: mst.GetStateActions(context.Background(), 15),
+	})
+	assert.Nil(t, mst.GetStateActions(context.Background(), 3))
+
+	e := &testEntity{}
+	_, err := mst.DoAction(context.Background(), e, "sign_d")
+	require.NoError(t, err)
+	_, err = mst.DoAction(context.Background(), e, "sign_f")
+	assert.ErrorIs(t, err, multistate.ErrInvalidAction)
+
+	var event multistate.TransitionEvent
+	mst.OnTransitionCommitted(func(_ context.Context, ev multistate.TransitionEvent) { event = ev })
+
+	newState, err := mst.DoAction(context.Background(), e, "sign_e")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(15), newState)
+	assert.Equal(t, []multistate.StateFlag{
+		{Id: "signed_e", Bit: 1, Caption: "Signed E"},
+		{Id: "signed_f", Bit: 2, Caption: "Signed F"},
+		{Id: "archived", Bit: 3, Caption: "Archived"},
+	}, event.SetFlags)
+	assert.Empty(t, event.ResetFlags)
+	assert.Equal(t, []string{"sign_d 0->1", "sign_e 1->15", "sign_f 3->7", "archive 7->15"}, fired)
+
+	report, err := mst.Analyze(nil)
+	require.NoError(t, err)
+	assert.Empty(t, report.DeadActions)
+
+	assert.EqualError(t, mst.SetAutomatic("sign_d"), "action 'sign_d' can't be made automatic, multistate is already compiled")
+	assert.True(t, mst.IsAutomatic("sign_f"))
+	assert.False(t, mst.IsAutomatic("sign_d"))
+	assert.False(t, mst.IsAutomatic("unknown"))
+	assert.Equal(t, []string{"sign_d"}, mst.GetStateActions(context.Background(), 2))
+
+	fromStruct := multistate.NewFromStruct(&automaticImpl{})
+	newState, err = fromStruct.DoAction(context.Background(), &testEntity{}, "submit")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), newState)
+	assert.Equal(t, []string{"submit"}, fromStruct.GetStateActions(context.Background(), 0))
+
+	loop := multistate.New("New")
+	started := loop.MustAddState(0, "started", "Started")
+	blinking := loop.MustAddState(1, "blinking", "Blinking")
+	loop.MustAddAction("start", "Start", Not(started), multistate.States{started}, nil, nil, nil)
+	loop.MustAddAction("on", "On", And(started, Not(blinking)), multistate.States{blinking}, nil, nil, nil)
+	loop.MustAddAction("off", "Off", And(started, blinking), nil, multistate.States{blinking}, nil, nil)
+	loop.MustSetAutomatic("on")
+	loop.MustSetAutomatic("off")
+	assert.EqualError(t, loop.Compile(), "action 'start' from 0: action 'off' from 3 returns to 1: automatic_loop_error")
+
+	chained := newIndependentFlagsMultistate(3)
+	chained.MustSetAutomatic("set_1")
+	chained.MustSetAutomatic("set_2")
+	err = chained.Compile(multistate.WithMaxAutomaticSteps(1))
+	assert.EqualError(t, err, "action 'set_0' from 0: more than 1 automatic steps from 1: automatic_loop_error")
+}
+
+type automaticImpl struct {
+	Submitted multistate.State `bit:"0"`
+	Checked   multistate.State `bit:"1"`
+}
+
+func (i *automaticImpl) ActionSubmit() multistate.Action {
+	return multistate.Action{From: Not(i.Submitted), Set: multistate.States{i.Submitted}}
+}
+
+func (i *automaticImpl) ActionCheck() multistate.Action {
+	return multistate.Action{From: And(i.Submitted, Not(i.Checked)), Set: multistate.States{i.Checked}, Automatic: true}
+}
+
+type failingSetEntity struct {
+	testEntity
+}
+
+func (*failingSetEntity) SetState(context.Context, uint64, ...interface{}) error {
+	return errors.New("disk full")
+}
+
+func TestMultistate_Compensation(t *testing.T) {
+	mst := multistate.New("New")
+
+	charged := mst.MustAddState(0, "charged", "Charged")
+	notified := mst.MustAddState(1, "notified", "Notified")
+
+	var log []string
+	step := func(name string, err error) multistate.ActionDoFunc {
+		return func(context.Context, multistate.Entity, ...interface{}) error {
+			log = append(log, name)
+			return err
+		}
+	}
+
+	mst.MustAddAction("charge", "Charge", Not(charged), multistate.States{charged}, nil, step("charge", nil), nil)
+	mst.MustAddAction("notify", "Notify", And(charged, Not(notified)), multistate.States{notified}, nil, step("notify", nil), nil)
+	mst.MustSetAutomatic("notify")
+	mst.MustSetCompensation("charge", step("refund", errors.New("refund failed")))
+	mst.MustSetCompensation("notify", step("apologize", nil))
+	mst.MustCompile()
+
+	_, err := mst.DoAction(context.Background(), &failingSetEntity{}, "charge")
+	assert.ErrorIs(t, err, multistate.ErrSetState)
+	assert.EqualError(t, err, "set_state_error: disk full; compensate 'charge': refund failed")
+	assert.Equal(t, []string{"charge", "notify", "apologize", "refund"}, log)
+
+	var actionErr *multistate.ActionError
+	require.ErrorAs(t, err, &actionErr)
+	assert.Equal(t, multistate.PhaseSetState, actionErr.Phase)
+	assert.EqualError(t, actionErr.Compensation, "compensate 'charge': refund failed")
+
+	log = nil
+	_, err = mst.DoAction(context.Background(), &failingEndEntity{}, "charge")
+	assert.EqualError(t, err, "commit failed; compensate 'charge': refund failed")
+	assert.Equal(t, []string{"charge", "notify", "apologize", "refund"}, log)
+
+	log = nil
+	_, err = mst.DoAction(context.Background(), &testEntity{}, "charge")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"charge", "notify"}, log)
+
+	assert.Error(t, mst.SetCompensation("unknown", nil))
+
+	impl := &compensatedImpl{log: &log}
+	fromStruct := multistate.NewFromStruct(impl)
+	log = nil
+	_, err = fromStruct.DoAction(context.Background(), &failingSetEntity{}, "charge")
+	assert.ErrorIs(t, err, multistate.ErrSetState)
+	assert.Equal(t, []string{"charge", "refund"}, log)
+}
+
+type compensatedImpl struct {
+	Charged multistate.State `bit:"0"`
+
+	log *[]string
+}
+
+func (i *compensatedImpl) ActionCharge() multistate.Action {
+	return multistate.Action{
+		From: Not(i.Charged),
+		Set:  multistate.States{i.Charged},
+		OnDo: func(context.Context, multistate.Entity, ...interface{}) error {
+			*i.log = append(*i.log, "charge")
+			return nil
+		},
+		Compensate: func(context.Context, multistate.Entity, ...interface{}) error {
+			*i.log = append(*i.log, "refund")
+			return nil
+		},
+	}
+}
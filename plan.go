@@ -0,0 +1,156 @@
+package multistate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-qbit/multistate/expr"
+)
+
+type PlanOption func(*planOptions)
+
+type planOptions struct {
+	ctx    context.Context
+	entity Entity
+	filter bool
+}
+
+// WithAvailableActions makes the planner skip the actions unavailable in the
+// context. The entity may be nil to check only the state guards.
+func WithAvailableActions(ctx context.Context, entity Entity) PlanOption {
+	return func(o *planOptions) {
+		o.ctx = ctx
+		o.entity = entity
+		o.filter = true
+	}
+}
+
+type planEdge struct {
+	from   uint64
+	action string
+}
+
+// PlanPath returns a shortest sequence of actions leading from the state to
+// any state satisfying the goal. The sequence is empty if the state already
+// satisfies the goal.
+func (m *Multistate) PlanPath(from uint64, goal expr.Expression, opts ...PlanOption) ([]string, error) {
+	preds, goals, err := m.plan(from, goal, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	path := []string{}
+	for state := goals[0]; state != from; {
+		edge := preds[state][0]
+		path = append(path, edge.action)
+		state = edge.from
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return path, nil
+}
+
+// PlanAllPaths returns every shortest sequence of actions leading from the
+// state to a state satisfying the goal, sorted.
+func (m *Multistate) PlanAllPaths(from uint64, goal expr.Expression, opts ...PlanOption) ([][]string, error) {
+	preds, goals, err := m.plan(from, goal, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var res [][]string
+	var walk func(state uint64, suffix []string)
+	walk = func(state uint64, suffix []string) {
+		if state == from {
+			path := make([]string, len(suffix))
+			for i := range suffix {
+				path[i] = suffix[len(suffix)-1-i]
+			}
+			res = append(res, path)
+			return
+		}
+
+		for _, edge := range preds[state] {
+			walk(edge.from, append(suffix, edge.action))
+		}
+	}
+
+	for _, state := range goals {
+		walk(state, make([]string, 0, 8))
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		for k := range res[i] {
+			if res[i][k] != res[j][k] {
+				return res[i][k] < res[j][k]
+			}
+		}
+		return false
+	})
+
+	return res, nil
+}
+
+// plan searches the graph breadth-first and returns the predecessors of the
+// states on the shortest paths together with the reached goal states.
+func (m *Multistate) plan(from uint64, goal expr.Expression, opts []PlanOption) (map[uint64][]planEdge, []uint64, error) {
+	var o planOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if _, exists := m.transitions(from); !exists {
+		return nil, nil, fmt.Errorf("state %d: %w", from, ErrInvalidState)
+	}
+
+	if goal.Eval(from) {
+		return nil, []uint64{from}, nil
+	}
+
+	preds := make(map[uint64][]planEdge)
+	depth := map[uint64]int{from: 0}
+	level := []uint64{from}
+
+	for d := 1; len(level) > 0; d++ {
+		var next, goals []uint64
+
+		for _, state := range level {
+			actions, _ := m.transitions(state)
+
+			ids := make([]string, 0, len(actions))
+			for id := range actions {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+
+			for _, id := range ids {
+				if o.filter && len(m.actionsMap[id].unavailableReasons(o.ctx, o.entity, state)) > 0 {
+					continue
+				}
+
+				to := actions[id]
+				if dt, seen := depth[to]; seen && dt < d {
+					continue
+				} else if !seen {
+					depth[to] = d
+					next = append(next, to)
+					if goal.Eval(to) {
+						goals = append(goals, to)
+					}
+				}
+				preds[to] = append(preds[to], planEdge{state, id})
+			}
+		}
+
+		if len(goals) > 0 {
+			return preds, goals, nil
+		}
+		level = next
+	}
+
+	return nil, nil, fmt.Errorf("state %d: %w", from, ErrNoPath)
+}
@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store keeping the timers in memory, keyed by the fmt.Sprint
+// representation of the entity ids.
+type MemoryStore struct {
+	mu     sync.Mutex
+	timers map[memoryKey]Timer
+}
+
+type memoryKey struct {
+	entityId string
+	action   string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		timers: make(map[memoryKey]Timer),
+	}
+}
+
+func (s *MemoryStore) AddTimer(_ context.Context, t Timer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timers[memoryKey{fmt.Sprint(t.EntityId), t.Action}] = t
+
+	return nil
+}
+
+func (s *MemoryStore) RemoveTimer(_ context.Context, entityId interface{}, action string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.timers, memoryKey{fmt.Sprint(entityId), action})
+
+	return nil
+}
+
+// DueTimers returns the timers due by now in the order of their due time.
+func (s *MemoryStore) DueTimers(_ context.Context, now time.Time) ([]Timer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []Timer
+	for _, t := range s.timers {
+		if !t.Due.After(now) {
+			res = append(res, t)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Due.Before(res[j].Due) })
+
+	return res, nil
+}
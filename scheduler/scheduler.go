@@ -0,0 +1,200 @@
+// Package scheduler fires the timed actions of a multistate: an action
+// becomes due after a delay once an entity enters the states matching an
+// expression and is cancelled when the entity leaves them.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-qbit/multistate"
+	"github.com/go-qbit/multistate/expr"
+)
+
+// Timer is a pending timed action of an entity.
+type Timer struct {
+	EntityId interface{}
+	Action   string
+	Due      time.Time
+}
+
+// Store keeps the pending timers. A timer is identified by its entity id and
+// action, adding it again replaces the due time.
+type Store interface {
+	AddTimer(ctx context.Context, t Timer) error
+	RemoveTimer(ctx context.Context, entityId interface{}, action string) error
+	DueTimers(ctx context.Context, now time.Time) ([]Timer, error)
+}
+
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// EntityLoader returns the entity to do the timed action with.
+type EntityLoader func(ctx context.Context, entityId interface{}) (multistate.Entity, error)
+
+type Option func(*Scheduler)
+
+// WithClock replaces the system clock, mostly for tests.
+func WithClock(c Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = c
+	}
+}
+
+// WithErrorHandler receives the store errors of the transitions, which
+// can't be returned to DoAction, and the errors of RunDue called by Run.
+// They are logged by default.
+func WithErrorHandler(h func(error)) Option {
+	return func(s *Scheduler) {
+		s.onError = h
+	}
+}
+
+type rule struct {
+	action string
+	when   expr.Expression
+	delay  time.Duration
+}
+
+type Scheduler struct {
+	mst     *multistate.Multistate
+	store   Store
+	load    EntityLoader
+	clock   Clock
+	onError func(error)
+	rules   []rule
+}
+
+// New creates a scheduler watching the committed transitions of the
+// multistate.
+func New(mst *multistate.Multistate, store Store, load EntityLoader, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		mst:     mst,
+		store:   store,
+		load:    load,
+		clock:   systemClock{},
+		onError: func(err error) { log.Printf("scheduler: %v", err) },
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mst.OnTransitionCommitted(s.onTransition)
+
+	return s
+}
+
+// After makes the action due the delay after an entity enters the states
+// matching the expression. The timers are kept by entity and action, so an
+// action has a single rule, and automatic actions, which can't be done by
+// DoAction, have none.
+func (s *Scheduler) After(action string, when expr.Expression, delay time.Duration) error {
+	if !s.mst.HasAction(action) {
+		return fmt.Errorf("action '%s': %w", action, multistate.ErrInvalidAction)
+	}
+	if s.mst.IsAutomatic(action) {
+		return fmt.Errorf("action '%s' is automatic: %w", action, multistate.ErrInvalidAction)
+	}
+	for _, r := range s.rules {
+		if r.action == action {
+			return fmt.Errorf("action '%s' is already scheduled", action)
+		}
+	}
+
+	s.rules = append(s.rules, rule{action, when, delay})
+
+	return nil
+}
+
+func (s *Scheduler) MustAfter(action string, when expr.Expression, delay time.Duration) {
+	if err := s.After(action, when, delay); err != nil {
+		panic(err)
+	}
+}
+
+func (s *Scheduler) onTransition(ctx context.Context, event multistate.TransitionEvent) {
+	now := s.clock.Now()
+
+	for _, r := range s.rules {
+		matches, matched := r.when.Eval(event.ToState), r.when.Eval(event.FromState)
+
+		var err error
+		switch {
+		case matches && !matched:
+			err = s.store.AddTimer(ctx, Timer{EntityId: event.EntityId, Action: r.action, Due: now.Add(r.delay)})
+		case !matches && matched:
+			err = s.store.RemoveTimer(ctx, event.EntityId, r.action)
+		}
+		if err != nil {
+			s.onError(fmt.Errorf("entity %v, timer '%s': %w", event.EntityId, r.action, err))
+		}
+	}
+}
+
+// RunDue does the due actions and returns how many succeeded. A timer is
+// removed once its action is done, or when the action is no longer valid for
+// the entity, e.g. its state was changed bypassing the events. Any other
+// failure keeps the timer, so the action is retried by the next run.
+func (s *Scheduler) RunDue(ctx context.Context) (int, error) {
+	timers, err := s.store.DueTimers(ctx, s.clock.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	var done int
+	var errs []error
+	for _, t := range timers {
+		if err := s.runTimer(ctx, t); err != nil {
+			errs = append(errs, fmt.Errorf("entity %v, timer '%s': %w", t.EntityId, t.Action, err))
+			continue
+		}
+		done++
+	}
+
+	return done, errors.Join(errs...)
+}
+
+func (s *Scheduler) runTimer(ctx context.Context, t Timer) error {
+	entity, err := s.load(ctx, t.EntityId)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.mst.DoAction(ctx, entity, t.Action)
+	if err != nil && !errors.Is(err, multistate.ErrInvalidAction) && !errors.Is(err, multistate.ErrInvalidState) {
+		return err
+	}
+
+	if rmErr := s.store.RemoveTimer(ctx, t.EntityId, t.Action); rmErr != nil {
+		return errors.Join(err, rmErr)
+	}
+
+	return err
+}
+
+// Run calls RunDue every interval until the context is done. The errors of
+// RunDue go to the error handler.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.RunDue(ctx); err != nil {
+			s.onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-qbit/multistate"
+	. "github.com/go-qbit/multistate/expr"
+	"github.com/go-qbit/multistate/internal/testfixture"
+	"github.com/go-qbit/multistate/scheduler"
+)
+
+// failingEntity fails SetState with err when it is set.
+type failingEntity struct {
+	testfixture.Entity
+	err error
+}
+
+func (e *failingEntity) SetState(ctx context.Context, s uint64, params ...interface{}) error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.Entity.SetState(ctx, s, params...)
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func TestScheduler(t *testing.T) {
+	mst := multistate.New("Draft")
+
+	awaiting := mst.MustAddState(0, "awaiting_signature", "Awaiting signature")
+	signed := mst.MustAddState(1, "signed", "Signed")
+	expired := mst.MustAddState(2, "expired", "Expired")
+
+	mst.MustAddAction("send", "Send", Empty(), multistate.States{awaiting}, nil, nil, nil)
+	mst.MustAddAction("sign", "Sign", awaiting, multistate.States{signed}, multistate.States{awaiting}, nil, nil)
+	mst.MustAddAction("expire", "Expire", awaiting, multistate.States{expired}, multistate.States{awaiting}, nil, nil)
+	mst.MustCompile()
+
+	entities := map[interface{}]*failingEntity{1: {Entity: testfixture.Entity{Id: 1}}, 2: {Entity: testfixture.Entity{Id: 2}}, 3: {Entity: testfixture.Entity{Id: 3}}}
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	sched := scheduler.New(mst, scheduler.NewMemoryStore(),
+		func(_ context.Context, id interface{}) (multistate.Entity, error) {
+			if e, exists := entities[id]; exists {
+				return e, nil
+			}
+			return nil, errors.New("not found")
+		},
+		scheduler.WithClock(clock),
+	)
+	sched.MustAfter("expire", awaiting, 30*24*time.Hour)
+	assert.ErrorIs(t, sched.After("archive", signed, time.Hour), multistate.ErrInvalidAction)
+
+	ctx := context.Background()
+	for _, id := range []int{1, 2} {
+		_, err := mst.DoAction(ctx, entities[id], "send")
+		require.NoError(t, err)
+	}
+
+	clock.now = clock.now.Add(24 * time.Hour)
+	_, err := mst.DoAction(ctx, entities[3], "send")
+	require.NoError(t, err)
+
+	_, err = mst.DoAction(ctx, entities[2], "sign")
+	require.NoError(t, err)
+
+	clock.now = clock.now.Add(29*24*time.Hour - time.Second)
+	done, err := sched.RunDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, done)
+
+	clock.now = clock.now.Add(time.Second)
+	done, err = sched.RunDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, done)
+	assert.Equal(t, []uint64{4, 2, 1}, []uint64{entities[1].State, entities[2].State, entities[3].State})
+
+	// A failure keeps the timer for the next run
+	entity3 := entities[3]
+	delete(entities, 3)
+	clock.now = clock.now.Add(24 * time.Hour)
+	done, err = sched.RunDue(ctx)
+	assert.EqualError(t, err, "entity 3, timer 'expire': not found")
+	assert.Equal(t, 0, done)
+
+	entities[3] = entity3
+	entity3.err = errors.New("connection lost")
+	done, err = sched.RunDue(ctx)
+	assert.ErrorIs(t, err, entity3.err)
+	assert.Equal(t, 0, done)
+
+	entity3.err = nil
+	done, err = sched.RunDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, done)
+	assert.Equal(t, uint64(4), entity3.State)
+
+	done, err = sched.RunDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, done)
+
+	// A timer no longer valid for the entity is dropped
+	entities[4] = &failingEntity{Entity: testfixture.Entity{Id: 4}}
+	_, err = mst.DoAction(ctx, entities[4], "send")
+	require.NoError(t, err)
+	entities[4].State = 2
+
+	clock.now = clock.now.Add(30 * 24 * time.Hour)
+	done, err = sched.RunDue(ctx)
+	assert.ErrorIs(t, err, multistate.ErrInvalidAction)
+	assert.Equal(t, 0, done)
+
+	done, err = sched.RunDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, done)
+}
+
+type failingStore struct {
+	*scheduler.MemoryStore
+	err error
+}
+
+func (s failingStore) AddTimer(context.Context, scheduler.Timer) error { return s.err }
+
+func TestScheduler_StoreErrors(t *testing.T) {
+	mst := multistate.New("Draft")
+	awaiting := mst.MustAddState(0, "awaiting_signature", "Awaiting signature")
+	expired := mst.MustAddState(1, "expired", "Expired")
+	mst.MustAddAction("send", "Send", Empty(), multistate.States{awaiting}, nil, nil, nil)
+	mst.MustAddAction("expire", "Expire", awaiting, multistate.States{expired}, multistate.States{awaiting}, nil, nil)
+	mst.MustCompile()
+
+	var handled []error
+	store := failingStore{scheduler.NewMemoryStore(), errors.New("store is read-only")}
+	sched := scheduler.New(mst, store, nil, scheduler.WithErrorHandler(func(err error) {
+		handled = append(handled, err)
+	}))
+	sched.MustAfter("expire", awaiting, time.Hour)
+
+	_, err := mst.DoAction(context.Background(), &testfixture.Entity{Id: 1}, "send")
+	require.NoError(t, err)
+
+	require.Len(t, handled, 1)
+	assert.EqualError(t, handled[0], "entity 1, timer 'expire': store is read-only")
+}
+
+func TestScheduler_After(t *testing.T) {
+	mst := multistate.New("Draft")
+	awaiting := mst.MustAddState(0, "awaiting_signature", "Awaiting signature")
+	expired := mst.MustAddState(1, "expired", "Expired")
+	archived := mst.MustAddState(2, "archived", "Archived")
+	mst.MustAddAction("send", "Send", Empty(), multistate.States{awaiting}, nil, nil, nil)
+	mst.MustAddAction("expire", "Expire", awaiting, multistate.States{expired}, multistate.States{awaiting}, nil, nil)
+	mst.MustAddAction("archive", "Archive", And(expired, Not(archived)), multistate.States{archived}, nil, nil, nil)
+	mst.MustSetAutomatic("archive")
+	mst.MustCompile()
+
+	sched := scheduler.New(mst, scheduler.NewMemoryStore(), nil)
+	assert.EqualError(t, sched.After("archive", expired, time.Hour), "action 'archive' is automatic: invalid_action_error")
+
+	sched.MustAfter("expire", awaiting, time.Hour)
+	assert.EqualError(t, sched.After("expire", awaiting, 2*time.Hour), "action 'expire' is already scheduled")
+}
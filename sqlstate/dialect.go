@@ -0,0 +1,36 @@
+package sqlstate
+
+import "fmt"
+
+// Dialect describes the SQL syntax differences between the databases.
+type Dialect struct {
+	Name string
+
+	// Placeholder formats the n-th query parameter, starting from 1.
+	Placeholder func(n int) string
+
+	// LockClause is appended to the SELECT reading the state to lock the row
+	// until the end of the transaction.
+	LockClause string
+
+	// UnsignedBitwise is set when the bitwise operators return unsigned
+	// 64-bit values, so the masks are written as unsigned numbers.
+	UnsignedBitwise bool
+}
+
+func questionPlaceholder(int) string {
+	return "?"
+}
+
+func dollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+var (
+	PostgreSQL = Dialect{Name: "postgresql", Placeholder: dollarPlaceholder, LockClause: " FOR UPDATE"}
+	MySQL      = Dialect{Name: "mysql", Placeholder: questionPlaceholder, LockClause: " FOR UPDATE", UnsignedBitwise: true}
+
+	// SQLite locks the whole database for writing, use BEGIN IMMEDIATE
+	// transactions or rely on the compare-and-set state update.
+	SQLite = Dialect{Name: "sqlite", Placeholder: questionPlaceholder}
+)
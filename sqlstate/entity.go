@@ -0,0 +1,175 @@
+package sqlstate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Table maps multistate entities to the rows of a table with an id column and
+// a state column holding the int64 bit pattern of the state.
+type Table struct {
+	db          *sql.DB
+	dialect     Dialect
+	name        string
+	idColumn    string
+	stateColumn string
+}
+
+func NewTable(db *sql.DB, dialect Dialect, table, idColumn, stateColumn string) *Table {
+	return &Table{
+		db:          db,
+		dialect:     dialect,
+		name:        table,
+		idColumn:    idColumn,
+		stateColumn: stateColumn,
+	}
+}
+
+// Entity returns the multistate.Entity of the row with the id.
+func (t *Table) Entity(id interface{}) *Entity {
+	return &Entity{table: t, id: id}
+}
+
+// Entity is a multistate.Entity and multistate.CASEntity stored in a table
+// row. StartAction begins a transaction, unless the context already carries
+// one, GetState locks the row and EndAction commits or rolls back the
+// transaction it began.
+type Entity struct {
+	table *Table
+	id    interface{}
+}
+
+type txKey struct{}
+
+type txValue struct {
+	tx    *sql.Tx
+	owned bool
+}
+
+// WithTx makes the entities use the transaction instead of beginning their
+// own ones. The caller is responsible for committing it.
+func WithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, &txValue{tx: tx})
+}
+
+// TxFromContext returns the transaction of the action, so callbacks can do
+// their queries within it.
+func TxFromContext(ctx context.Context) *sql.Tx {
+	if v, ok := ctx.Value(txKey{}).(*txValue); ok {
+		return v.tx
+	}
+
+	return nil
+}
+
+func (e *Entity) GetId() interface{} {
+	return e.id
+}
+
+func (e *Entity) StartAction(ctx context.Context) (context.Context, error) {
+	if TxFromContext(ctx) != nil {
+		return ctx, nil
+	}
+
+	tx, err := e.table.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ctx, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	return context.WithValue(ctx, txKey{}, &txValue{tx: tx, owned: true}), nil
+}
+
+func (e *Entity) GetState(ctx context.Context) (uint64, error) {
+	tx := TxFromContext(ctx)
+	if tx == nil {
+		return 0, fmt.Errorf("entity %v: no transaction in the context", e.id)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s%s",
+		e.table.stateColumn, e.table.name, e.table.idColumn, e.table.dialect.Placeholder(1), e.table.dialect.LockClause)
+
+	var state int64
+	if err := tx.QueryRowContext(ctx, query, e.id).Scan(&state); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("entity %v not found: %w", e.id, err)
+		}
+		return 0, fmt.Errorf("entity %v: cannot get state: %w", e.id, err)
+	}
+
+	return uint64(state), nil
+}
+
+func (e *Entity) SetState(ctx context.Context, newState uint64, _ ...interface{}) error {
+	tx := TxFromContext(ctx)
+	if tx == nil {
+		return fmt.Errorf("entity %v: no transaction in the context", e.id)
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = %s",
+		e.table.name, e.table.stateColumn, e.table.dialect.Placeholder(1), e.table.idColumn, e.table.dialect.Placeholder(2))
+
+	res, err := tx.ExecContext(ctx, query, int64(newState), e.id)
+	if err != nil {
+		return fmt.Errorf("entity %v: cannot set state: %w", e.id, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("entity %v not found: %w", e.id, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// CompareAndSetState updates the state only if it is still expected, which
+// protects the databases without row locks.
+func (e *Entity) CompareAndSetState(ctx context.Context, expected, newState uint64) (bool, error) {
+	if expected == newState {
+		// Some databases don't count unchanged rows as affected
+		state, err := e.GetState(ctx)
+		return state == expected, err
+	}
+
+	tx := TxFromContext(ctx)
+	if tx == nil {
+		return false, fmt.Errorf("entity %v: no transaction in the context", e.id)
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = %s AND %s = %s",
+		e.table.name, e.table.stateColumn, e.table.dialect.Placeholder(1),
+		e.table.idColumn, e.table.dialect.Placeholder(2),
+		e.table.stateColumn, e.table.dialect.Placeholder(3))
+
+	res, err := tx.ExecContext(ctx, query, int64(newState), e.id, int64(expected))
+	if err != nil {
+		return false, fmt.Errorf("entity %v: cannot set state: %w", e.id, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("entity %v: cannot set state: %w", e.id, err)
+	}
+
+	return n > 0, nil
+}
+
+func (e *Entity) EndAction(ctx context.Context, err error) error {
+	v, ok := ctx.Value(txKey{}).(*txValue)
+	if !ok || !v.owned {
+		return err
+	}
+
+	if err != nil {
+		if rbErr := v.tx.Rollback(); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("cannot rollback transaction: %w", rbErr))
+		}
+		return err
+	}
+
+	if err := v.tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit transaction: %w", err)
+	}
+
+	return nil
+}
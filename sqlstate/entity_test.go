@@ -0,0 +1,128 @@
+package sqlstate_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-qbit/multistate/internal/testfixture"
+	"github.com/go-qbit/multistate/sqlstate"
+)
+
+func TestEntity(t *testing.T) {
+	states := &stateTable{states: map[int64]int64{7: 0}}
+	c := testfixture.NewConnector(states)
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	mst := testfixture.NewSignMultistate()
+	table := sqlstate.NewTable(db, sqlstate.PostgreSQL, "contracts", "id", "state")
+
+	newState, err := mst.DoAction(context.Background(), table.Entity(int64(7)), "sign_a")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), newState)
+	assert.Equal(t, int64(1), states.states[7])
+	assert.Equal(t, []string{
+		"BEGIN",
+		"SELECT state FROM contracts WHERE id = $1 FOR UPDATE",
+		"UPDATE contracts SET state = $1 WHERE id = $2 AND state = $3",
+		"COMMIT",
+	}, c.Log())
+
+	c.ResetLog()
+	_, err = mst.DoAction(context.Background(), table.Entity(int64(7)), "sign_b")
+	require.Error(t, err)
+	assert.Equal(t, int64(1), states.states[7])
+	assert.Equal(t, []string{"BEGIN", "SELECT state FROM contracts WHERE id = $1 FOR UPDATE", "ROLLBACK"}, c.Log())
+
+	_, err = mst.DoAction(context.Background(), table.Entity(int64(8)), "sign_a")
+	assert.EqualError(t, err, "entity 8 not found: sql: no rows in result set")
+}
+
+func TestEntity_ExternalTx(t *testing.T) {
+	states := &stateTable{states: map[int64]int64{7: 0}}
+	c := testfixture.NewConnector(states)
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	mst := testfixture.NewSignMultistate()
+	table := sqlstate.NewTable(db, sqlstate.SQLite, "contracts", "id", "state")
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+
+	ctx := sqlstate.WithTx(context.Background(), tx)
+	_, err = mst.DoAction(ctx, table.Entity(int64(7)), "sign_a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), states.states[7])
+
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, int64(1), states.states[7])
+	assert.Equal(t, []string{
+		"BEGIN",
+		"SELECT state FROM contracts WHERE id = ?",
+		"UPDATE contracts SET state = ? WHERE id = ? AND state = ?",
+		"COMMIT",
+	}, c.Log())
+}
+
+// stateTable emulates a table of states with transactions, enough to run the
+// queries of Entity.
+type stateTable struct {
+	states  map[int64]int64
+	pending map[int64]int64
+}
+
+func (t *stateTable) Begin() error {
+	t.pending = make(map[int64]int64, len(t.states))
+	for id, state := range t.states {
+		t.pending[id] = state
+	}
+
+	return nil
+}
+
+func (t *stateTable) Commit() error {
+	t.states, t.pending = t.pending, nil
+
+	return nil
+}
+
+func (t *stateTable) Rollback() error {
+	t.pending = nil
+
+	return nil
+}
+
+func (t *stateTable) Exec(_ string, args []driver.Value) (driver.Result, error) {
+	if t.pending == nil {
+		return nil, errors.New("no transaction")
+	}
+
+	id := args[1].(int64)
+	state, exists := t.pending[id]
+	if !exists || len(args) > 2 && state != args[2].(int64) {
+		return driver.RowsAffected(0), nil
+	}
+	t.pending[id] = args[0].(int64)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (t *stateTable) Query(_ string, args []driver.Value) (driver.Rows, error) {
+	if t.pending == nil {
+		return nil, errors.New("no transaction")
+	}
+
+	var rows [][]driver.Value
+	if state, exists := t.pending[args[0].(int64)]; exists {
+		rows = append(rows, []driver.Value{state})
+	}
+
+	return testfixture.Rows([]string{"state"}, rows), nil
+}
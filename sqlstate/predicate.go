@@ -0,0 +1,273 @@
+package sqlstate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-qbit/multistate"
+	"github.com/go-qbit/multistate/expr"
+)
+
+// PredicateBuilder turns state requirements into SQL predicates on the
+// bitmask column, e.g. "state & 5 = 5 AND state & 8 = 0", instead of listing
+// every matching multistate.
+type PredicateBuilder struct {
+	dialect Dialect
+	column  string
+	bits    map[string]uint8
+	enums   map[string]*multistate.Enum
+}
+
+func NewPredicateBuilder(m *multistate.Multistate, dialect Dialect, column string) *PredicateBuilder {
+	b := &PredicateBuilder{
+		dialect: dialect,
+		column:  column,
+		bits:    make(map[string]uint8),
+		enums:   make(map[string]*multistate.Enum),
+	}
+	for _, flag := range m.GetAllStateFlags() {
+		b.bits[flag.Id] = flag.Bit
+	}
+	for _, e := range m.GetEnums() {
+		b.enums[e.GetId()] = e
+	}
+
+	return b
+}
+
+// StateIds matches the states having any of the flags, like
+// Multistate.GetMultistatesByStateIds.
+func (b *PredicateBuilder) StateIds(stateIds ...string) (string, error) {
+	mask, err := b.mask(stateIds)
+	if err != nil {
+		return "", err
+	}
+	if mask == 0 {
+		return "1 = 0", nil
+	}
+
+	return fmt.Sprintf("%s & %s <> 0", b.column, b.number(mask)), nil
+}
+
+// RequiredAndForbidden matches the states having all the required flags and
+// none of the forbidden ones, like
+// Multistate.GetMultistatesByRequiredAndForbiddenStateIds.
+func (b *PredicateBuilder) RequiredAndForbidden(reqIds, forbIds []string) (string, error) {
+	required, err := b.mask(reqIds)
+	if err != nil {
+		return "", err
+	}
+
+	forbidden, err := b.mask(forbIds)
+	if err != nil {
+		return "", err
+	}
+
+	return b.masks(required, forbidden), nil
+}
+
+// Expression matches the states satisfying the expression. A predicate
+// joining terms with OR is parenthesized, so it can be combined with other
+// conditions as is.
+func (b *PredicateBuilder) Expression(e expr.Expression) (string, error) {
+	n, err := expr.ToNode(e)
+	if err != nil {
+		return "", err
+	}
+
+	s, err := b.node(n)
+	if err != nil {
+		return "", err
+	}
+	if (n.Op == expr.OpOr || n.Op == expr.OpXor) && strings.Contains(s, " OR ") {
+		return "(" + s + ")", nil
+	}
+
+	return s, nil
+}
+
+func (b *PredicateBuilder) mask(stateIds []string) (uint64, error) {
+	var mask uint64
+	for _, id := range stateIds {
+		bit, exists := b.bits[id]
+		if !exists {
+			return 0, fmt.Errorf("state id '%s': %w", id, multistate.ErrInvalidState)
+		}
+		mask |= 1 << bit
+	}
+
+	return mask, nil
+}
+
+// stateMask returns the bits of a state node: the bit of a flag, or the
+// field of an enum, set when the enum holds any value.
+func (b *PredicateBuilder) stateMask(id string) (uint64, error) {
+	if e, exists := b.enums[id]; exists {
+		return e.Mask(), nil
+	}
+
+	return b.mask([]string{id})
+}
+
+func (b *PredicateBuilder) number(v uint64) string {
+	if b.dialect.UnsignedBitwise {
+		return strconv.FormatUint(v, 10)
+	}
+
+	return strconv.FormatInt(int64(v), 10)
+}
+
+func (b *PredicateBuilder) masks(required, forbidden uint64) string {
+	var parts []string
+	if required&forbidden != 0 {
+		return "1 = 0"
+	}
+	if required != 0 {
+		parts = append(parts, fmt.Sprintf("%s & %s = %s", b.column, b.number(required), b.number(required)))
+	}
+	if forbidden != 0 {
+		parts = append(parts, fmt.Sprintf("%s & %s = 0", b.column, b.number(forbidden)))
+	}
+	if len(parts) == 0 {
+		return "1 = 1"
+	}
+
+	return strings.Join(parts, " AND ")
+}
+
+// flag returns the bit of a state node or a negated state node.
+func (b *PredicateBuilder) flag(n expr.Node) (uint64, bool, error) {
+	negated := false
+	if n.Op == expr.OpNot && len(n.Args) == 1 {
+		n, negated = n.Args[0], true
+	}
+	if n.Op != expr.OpState {
+		return 0, false, nil
+	}
+	// A field holding any value needs just one of its bits
+	if _, exists := b.enums[n.State]; exists && !negated {
+		return 0, false, nil
+	}
+
+	mask, err := b.stateMask(n.State)
+	if err != nil {
+		return 0, false, err
+	}
+	if negated {
+		return mask, false, nil
+	}
+
+	return mask, true, nil
+}
+
+func (b *PredicateBuilder) node(n expr.Node) (string, error) {
+	switch n.Op {
+	case expr.OpAny:
+		return "1 = 1", nil
+	case expr.OpEmpty:
+		return b.column + " = 0", nil
+	case expr.OpState:
+		mask, err := b.stateMask(n.State)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s & %s <> 0", b.column, b.number(mask)), nil
+	case expr.OpEnum:
+		e, exists := b.enums[n.State]
+		if !exists {
+			return "", fmt.Errorf("enum id '%s': %w", n.State, multistate.ErrInvalidState)
+		}
+		v, err := e.Encode(n.Value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s & %s = %s", b.column, b.number(e.Mask()), b.number(v)), nil
+	case expr.OpNot:
+		if len(n.Args) != 1 {
+			return "", fmt.Errorf("operation '%s' requires exactly 1 argument", n.Op)
+		}
+		if n.Args[0].Op == expr.OpState {
+			mask, err := b.stateMask(n.Args[0].State)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s & %s = 0", b.column, b.number(mask)), nil
+		}
+		arg, err := b.node(n.Args[0])
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + arg + ")", nil
+	case expr.OpAnd:
+		var required, forbidden uint64
+		var parts []string
+		for _, arg := range n.Args {
+			mask, set, err := b.flag(arg)
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case mask != 0 && set:
+				required |= mask
+			case mask != 0:
+				forbidden |= mask
+			default:
+				s, err := b.node(arg)
+				if err != nil {
+					return "", err
+				}
+				parts = append(parts, "("+s+")")
+			}
+		}
+		if required != 0 || forbidden != 0 || len(parts) == 0 {
+			parts = append([]string{b.masks(required, forbidden)}, parts...)
+		}
+		return strings.Join(parts, " AND "), nil
+	case expr.OpOr:
+		var mask uint64
+		var parts []string
+		for _, arg := range n.Args {
+			if arg.Op == expr.OpState {
+				m, err := b.stateMask(arg.State)
+				if err != nil {
+					return "", err
+				}
+				mask |= m
+				continue
+			}
+			s, err := b.node(arg)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, "("+s+")")
+		}
+		if mask != 0 {
+			parts = append([]string{fmt.Sprintf("%s & %s <> 0", b.column, b.number(mask))}, parts...)
+		}
+		return strings.Join(parts, " OR "), nil
+	case expr.OpXor:
+		// Exactly one of the arguments holds
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			s, err := b.node(arg)
+			if err != nil {
+				return "", err
+			}
+			args[i] = "(" + s + ")"
+		}
+		parts := make([]string, len(args))
+		for i := range args {
+			terms := []string{args[i]}
+			for j := range args {
+				if j != i {
+					terms = append(terms, "NOT "+args[j])
+				}
+			}
+			parts[i] = "(" + strings.Join(terms, " AND ") + ")"
+		}
+		return strings.Join(parts, " OR "), nil
+	}
+
+	return "", fmt.Errorf("unknown operation '%s'", n.Op)
+}
@@ -0,0 +1,86 @@
+package sqlstate_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-qbit/multistate"
+	. "github.com/go-qbit/multistate/expr"
+	"github.com/go-qbit/multistate/sqlstate"
+)
+
+func TestPredicateBuilder(t *testing.T) {
+	mst := multistate.New("New")
+	a := mst.MustAddState(0, "a", "A")
+	b := mst.MustAddState(2, "b", "B")
+	c := mst.MustAddState(3, "c", "C")
+	mst.MustAddState(63, "archived", "Archived")
+
+	pb := sqlstate.NewPredicateBuilder(mst, sqlstate.PostgreSQL, "state")
+
+	for expected, e := range map[string]Expression{
+		"state & 5 = 5 AND state & 8 = 0":                       And(a, b, Not(c)),
+		"state & 13 <> 0":                                       Or(a, b, c),
+		"state & 1 = 1 AND (state & 4 <> 0 OR (state & 8 = 0))": And(a, Or(b, Not(c))),
+		"state = 0":            Empty(),
+		"1 = 1":                Any(),
+		"NOT (state & 5 <> 0)": Not(Or(a, b)),
+		"1 = 0":                And(a, Not(a)),
+		"(((state & 1 <> 0) AND NOT (state & 4 <> 0)) OR ((state & 4 <> 0) AND NOT (state & 1 <> 0)))": Xor(a, b),
+		"(state & 1 <> 0 OR (state & 8 = 0))": Or(a, Not(c)),
+	} {
+		pred, err := pb.Expression(e)
+		require.NoError(t, err)
+		assert.Equal(t, expected, pred, String(e))
+	}
+
+	// The predicate keeps its meaning inside a larger condition
+	pred, err := pb.Expression(Or(a, Not(c)))
+	require.NoError(t, err)
+	assert.Equal(t, "deleted = 0 AND (state & 1 <> 0 OR (state & 8 = 0))", "deleted = 0 AND "+pred)
+
+	pred, err = pb.RequiredAndForbidden([]string{"a", "b"}, []string{"archived"})
+	require.NoError(t, err)
+	assert.Equal(t, "state & 5 = 5 AND state & -9223372036854775808 = 0", pred)
+
+	pred, err = sqlstate.NewPredicateBuilder(mst, sqlstate.MySQL, "state").RequiredAndForbidden(nil, []string{"archived"})
+	require.NoError(t, err)
+	assert.Equal(t, "state & 9223372036854775808 = 0", pred)
+
+	pred, err = pb.StateIds("a", "c")
+	require.NoError(t, err)
+	assert.Equal(t, "state & 9 <> 0", pred)
+
+	_, err = pb.StateIds("x")
+	assert.ErrorIs(t, err, multistate.ErrInvalidState)
+}
+
+func TestPredicateBuilder_Enum(t *testing.T) {
+	mst := multistate.New("New")
+	signed := mst.MustAddState(0, "signed", "Signed")
+	risk := mst.MustAddEnum(1, 2, "risk", "Risk", "low", "medium", "high")
+
+	pb := sqlstate.NewPredicateBuilder(mst, sqlstate.PostgreSQL, "state")
+
+	for expected, text := range map[string]string{
+		"state & 6 = 4":                              "risk == medium",
+		"state & 1 = 1 AND (state & 6 <> 0)":         "signed & risk",
+		"state & 7 = 0":                              "!signed & !risk",
+		"state & 7 <> 0":                             "signed | risk",
+		"state & 1 = 0 AND (state & 6 = 6)":          "!signed & risk == high",
+		"((state & 6 = 2) OR (NOT (state & 6 = 6)))": "risk == low | !risk == high",
+	} {
+		e, err := Parse(text, mst.ResolveState)
+		require.NoError(t, err, text)
+
+		pred, err := pb.Expression(e)
+		require.NoError(t, err, text)
+		assert.Equal(t, expected, pred, text)
+	}
+
+	pred, err := pb.Expression(And(signed, risk.Is("low")))
+	require.NoError(t, err)
+	assert.Equal(t, "state & 1 = 1 AND (state & 6 = 2)", pred)
+}
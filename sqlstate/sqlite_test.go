@@ -0,0 +1,115 @@
+//go:build sqlite
+
+package sqlstate_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/go-qbit/multistate"
+	. "github.com/go-qbit/multistate/expr"
+	"github.com/go-qbit/multistate/internal/testfixture"
+	"github.com/go-qbit/multistate/sqlstate"
+)
+
+// The tests run against a real database with: go test -tags sqlite ./...
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", "file:"+t.Name()+"?mode=memory&cache=shared")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// The shared in-memory database lives as long as a connection is open
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec("CREATE TABLE contracts (id INTEGER PRIMARY KEY, state INTEGER NOT NULL, deleted INTEGER NOT NULL DEFAULT 0)")
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestSQLite_Entity(t *testing.T) {
+	db := openSQLite(t)
+	_, err := db.Exec("INSERT INTO contracts (id, state) VALUES (7, 0)")
+	require.NoError(t, err)
+
+	mst := testfixture.NewSignMultistate()
+	table := sqlstate.NewTable(db, sqlstate.SQLite, "contracts", "id", "state")
+
+	newState, err := mst.DoAction(context.Background(), table.Entity(int64(7)), "sign_a")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), newState)
+
+	_, err = mst.DoAction(context.Background(), table.Entity(int64(7)), "sign_b")
+	require.Error(t, err)
+
+	var state int64
+	require.NoError(t, db.QueryRow("SELECT state FROM contracts WHERE id = 7").Scan(&state))
+	assert.Equal(t, int64(1), state)
+
+	_, err = mst.DoAction(context.Background(), table.Entity(int64(8)), "sign_a")
+	assert.EqualError(t, err, "entity 8 not found: sql: no rows in result set")
+}
+
+func TestSQLite_PredicateBuilder(t *testing.T) {
+	mst := multistate.New("New")
+	a := mst.MustAddState(0, "a", "A")
+	b := mst.MustAddState(2, "b", "B")
+	c := mst.MustAddState(3, "c", "C")
+	archived := mst.MustAddState(63, "archived", "Archived")
+	risk := mst.MustAddEnum(4, 2, "risk", "Risk", "low", "high")
+
+	db := openSQLite(t)
+	states := []uint64{0, 1, 4, 5, 8, 9, 13, 1 << 63, 1<<63 | 1, 1 | 1<<4, 4 | 2<<4, 12 | 3<<4}
+	for i, state := range states {
+		_, err := db.Exec("INSERT INTO contracts (id, state, deleted) VALUES (?, ?, ?)", i, int64(state), i%2)
+		require.NoError(t, err)
+	}
+
+	pb := sqlstate.NewPredicateBuilder(mst, sqlstate.SQLite, "state")
+	for _, e := range []Expression{
+		And(a, b, Not(c)),
+		Or(a, b, c),
+		And(a, Or(b, Not(c))),
+		Or(a, Not(c)),
+		Xor(a, b),
+		Xor(a, b, c),
+		Not(Or(a, b)),
+		Empty(),
+		archived,
+		And(archived, a),
+		Not(archived),
+		risk.Is("high"),
+		Or(risk.Is("low"), c),
+		And(risk, Not(a)),
+	} {
+		pred, err := pb.Expression(e)
+		require.NoError(t, err, String(e))
+
+		// The predicate keeps its meaning inside a larger condition
+		rows, err := db.Query("SELECT state FROM contracts WHERE deleted = 0 AND " + pred + " ORDER BY id")
+		require.NoError(t, err, pred)
+
+		var got []uint64
+		for rows.Next() {
+			var state int64
+			require.NoError(t, rows.Scan(&state))
+			got = append(got, uint64(state))
+		}
+		require.NoError(t, rows.Err())
+		rows.Close()
+
+		var expected []uint64
+		for i, state := range states {
+			if i%2 == 0 && e.Eval(state) {
+				expected = append(expected, state)
+			}
+		}
+		assert.Equal(t, expected, got, "%s: %s", String(e), pred)
+	}
+}
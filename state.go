@@ -1,5 +1,7 @@
 package multistate
 
+import "github.com/go-qbit/multistate/bitset"
+
 type State interface {
 	GetStateId() string
 	Eval(v uint64) bool
@@ -17,6 +19,14 @@ func (s *state) GetStateId() string {
 	return s.id
 }
 
+func (s *state) String() string {
+	return s.id
+}
+
 func (s *state) Eval(v uint64) bool {
 	return v&(1<<s.bit) > 0
 }
+
+func (s *state) EvalBitset(v bitset.Bitset) bool {
+	return v.Has(s.bit)
+}
@@ -0,0 +1,84 @@
+package multistate
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/go-qbit/multistate/bitset"
+	"github.com/go-qbit/multistate/expr"
+)
+
+// StateValue is the type of the states: uint64 for Multistate and
+// bitset.Bitset for BitsetMultistate.
+type StateValue interface {
+	uint64 | bitset.Bitset
+}
+
+// stateOps are the operations on the states the shared machine needs.
+type stateOps[S StateValue] interface {
+	or(a, b S) S
+	and(a, b S) S
+	andNot(a, b S) S
+	flag(bit uint8) S
+	has(s S, bit uint8) bool
+	isZero(s S) bool
+	less(a, b S) bool
+	eval(e expr.Expression, s S) bool
+
+	// low and fromLow convert the first 64 flags, where the enums live
+	low(s S) uint64
+	fromLow(v uint64) S
+
+	// size is the number of flags a state holds
+	size() int
+
+	// nodeId names the state in the graphs and binary feeds its color
+	nodeId(s S) string
+	binary(s S) []byte
+}
+
+func newStateOps[S StateValue]() stateOps[S] {
+	var s S
+	if _, ok := any(s).(uint64); ok {
+		return any(uint64Ops{}).(stateOps[S])
+	}
+
+	return any(bitsetOps{}).(stateOps[S])
+}
+
+type uint64Ops struct{}
+
+func (uint64Ops) or(a, b uint64) uint64                 { return a | b }
+func (uint64Ops) and(a, b uint64) uint64                { return a & b }
+func (uint64Ops) andNot(a, b uint64) uint64             { return a &^ b }
+func (uint64Ops) flag(bit uint8) uint64                 { return 1 << bit }
+func (uint64Ops) has(s uint64, bit uint8) bool          { return s&(1<<bit) != 0 }
+func (uint64Ops) isZero(s uint64) bool                  { return s == 0 }
+func (uint64Ops) less(a, b uint64) bool                 { return a < b }
+func (uint64Ops) eval(e expr.Expression, s uint64) bool { return e.Eval(s) }
+func (uint64Ops) low(s uint64) uint64                   { return s }
+func (uint64Ops) fromLow(v uint64) uint64               { return v }
+func (uint64Ops) size() int                             { return 64 }
+func (uint64Ops) nodeId(s uint64) string                { return strconv.FormatUint(s, 16) }
+func (uint64Ops) binary(s uint64) []byte                { return binary.LittleEndian.AppendUint64(nil, s) }
+
+type bitsetOps struct{}
+
+func (bitsetOps) or(a, b bitset.Bitset) bitset.Bitset          { return a.Or(b) }
+func (bitsetOps) and(a, b bitset.Bitset) bitset.Bitset         { return a.And(b) }
+func (bitsetOps) andNot(a, b bitset.Bitset) bitset.Bitset      { return a.AndNot(b) }
+func (bitsetOps) flag(bit uint8) bitset.Bitset                 { return bitset.Of(bit) }
+func (bitsetOps) has(s bitset.Bitset, bit uint8) bool          { return s.Has(bit) }
+func (bitsetOps) isZero(s bitset.Bitset) bool                  { return s.IsZero() }
+func (bitsetOps) less(a, b bitset.Bitset) bool                 { return a.Less(b) }
+func (bitsetOps) eval(e expr.Expression, s bitset.Bitset) bool { return expr.EvalBitset(e, s) }
+func (bitsetOps) fromLow(v uint64) bitset.Bitset               { return bitset.FromUint64(v) }
+func (bitsetOps) size() int                                    { return bitset.Size }
+func (bitsetOps) nodeId(s bitset.Bitset) string                { return strings.TrimPrefix(s.String(), "0x") }
+func (bitsetOps) binary(s bitset.Bitset) []byte                { return []byte(s.String()) }
+
+func (bitsetOps) low(s bitset.Bitset) uint64 {
+	v, _ := s.Uint64()
+	return v
+}
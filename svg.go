@@ -0,0 +1,422 @@
+package multistate
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	svgFontSize    = 12
+	svgLineHeight  = 16
+	svgCharWidth   = 7
+	svgCellPadding = 6
+	svgNodeGap     = 40
+	svgLayerGap    = 40
+	svgMargin      = 20
+	svgClusterPad  = 10
+	svgLoopSize    = 30
+)
+
+type svgNode struct {
+	index   int
+	color   string
+	title   string
+	lines   []string
+	cluster *cluster
+	layer   int
+	order   int
+	bary    float64
+	x, y    float64
+	w, h    float64
+	titleW  float64
+}
+
+type svgEdge struct {
+	from, to *svgNode
+	labels   []string
+}
+
+type svgLayout struct {
+	nodes         []*svgNode
+	layers        [][]*svgNode
+	edges         []*svgEdge
+	clusters      []cluster
+	width, height float64
+}
+
+// WriteSVG renders the compiled graph as SVG using a built-in layered layout.
+// Unlike GetGraphSVG it never requires external tools.
+func (m *machine[S]) WriteSVG(w io.Writer) error {
+	if m.IsLazy() {
+		return ErrLazyMode
+	}
+
+	l := m.svgLayout()
+
+	bw := bufio.NewWriter(w)
+	l.write(bw)
+
+	return bw.Flush()
+}
+
+func (m *machine[S]) svgLayout() *svgLayout {
+	l := &svgLayout{clusters: m.clusters}
+
+	nodes := map[S]*svgNode{}
+	for i, state := range m.sortedStates() {
+		n := &svgNode{
+			index:   i,
+			color:   hsvToRGB(stateColor(m.ops.binary(state))),
+			title:   fmt.Sprint(state),
+			cluster: m.stateClusterMap[state],
+		}
+		for _, flag := range m.GetStateFlags(state) {
+			n.lines = append(n.lines, fmt.Sprintf("[%2d] %s", flag.Bit, flag.Caption))
+		}
+		if len(n.lines) == 0 {
+			if m.emptyStateName != "" {
+				n.lines = []string{m.emptyStateName}
+			} else {
+				n.lines = []string{"EMPTY"}
+			}
+		}
+
+		n.titleW = svgTextWidth(n.title) + 2*svgCellPadding
+		n.w = n.titleW + maxTextWidth(n.lines) + 2*svgCellPadding
+		n.h = float64(len(n.lines))*svgLineHeight + 2*svgCellPadding
+
+		nodes[state] = n
+		l.nodes = append(l.nodes, n)
+	}
+
+	edges := map[[2]S]*svgEdge{}
+	for _, c := range m.GetConnections() {
+		key := [2]S{c.From, c.To}
+		e, exists := edges[key]
+		if !exists {
+			e = &svgEdge{from: nodes[c.From], to: nodes[c.To]}
+			edges[key] = e
+			l.edges = append(l.edges, e)
+		}
+		e.labels = append(e.labels, strings.Split(m.edgeLabel(c.Action), "\n")...)
+	}
+
+	l.assignLayers()
+	l.orderLayers()
+	l.assignCoordinates()
+
+	return l
+}
+
+// assignLayers places every state at its BFS distance from the empty state.
+func (l *svgLayout) assignLayers() {
+	succ := map[*svgNode][]*svgNode{}
+	for _, e := range l.edges {
+		succ[e.from] = append(succ[e.from], e.to)
+	}
+
+	visited := map[*svgNode]bool{}
+	for _, root := range l.nodes {
+		if visited[root] {
+			continue
+		}
+		visited[root] = true
+		root.layer = 0
+
+		queue := []*svgNode{root}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			for _, s := range succ[n] {
+				if !visited[s] {
+					visited[s] = true
+					s.layer = n.layer + 1
+					queue = append(queue, s)
+				}
+			}
+		}
+	}
+
+	for _, n := range l.nodes {
+		for len(l.layers) <= n.layer {
+			l.layers = append(l.layers, nil)
+		}
+		n.order = len(l.layers[n.layer])
+		l.layers[n.layer] = append(l.layers[n.layer], n)
+	}
+}
+
+// orderLayers reduces edge crossings with a few barycenter sweeps, keeping
+// the members of a cluster next to each other inside every layer.
+func (l *svgLayout) orderLayers() {
+	pred := map[*svgNode][]*svgNode{}
+	succ := map[*svgNode][]*svgNode{}
+	for _, e := range l.edges {
+		if e.to.layer == e.from.layer+1 {
+			succ[e.from] = append(succ[e.from], e.to)
+			pred[e.to] = append(pred[e.to], e.from)
+		}
+	}
+
+	for sweep := 0; sweep < 4; sweep++ {
+		for i := 1; i < len(l.layers); i++ {
+			l.sortLayer(l.layers[i], pred)
+		}
+		for i := len(l.layers) - 2; i >= 0; i-- {
+			l.sortLayer(l.layers[i], succ)
+		}
+	}
+}
+
+func (l *svgLayout) sortLayer(layer []*svgNode, neighbours map[*svgNode][]*svgNode) {
+	for _, n := range layer {
+		n.bary = float64(n.order)
+		if adj := neighbours[n]; len(adj) > 0 {
+			var sum float64
+			for _, a := range adj {
+				sum += float64(a.order)
+			}
+			n.bary = sum / float64(len(adj))
+		}
+	}
+
+	clusterBary := map[*cluster]float64{}
+	for _, n := range layer {
+		if n.cluster == nil {
+			continue
+		}
+		if b, exists := clusterBary[n.cluster]; !exists || n.bary < b {
+			clusterBary[n.cluster] = n.bary
+		}
+	}
+
+	groupKey := func(n *svgNode) (float64, int) {
+		if n.cluster == nil {
+			return n.bary, -1
+		}
+		return clusterBary[n.cluster], int(n.cluster.id)
+	}
+
+	sort.SliceStable(layer, func(i, j int) bool {
+		gi, ci := groupKey(layer[i])
+		gj, cj := groupKey(layer[j])
+		if gi != gj {
+			return gi < gj
+		}
+		if ci != cj {
+			return ci < cj
+		}
+		if layer[i].bary != layer[j].bary {
+			return layer[i].bary < layer[j].bary
+		}
+		return layer[i].index < layer[j].index
+	})
+
+	for i, n := range layer {
+		n.order = i
+	}
+}
+
+func (l *svgLayout) assignCoordinates() {
+	layerWidths := make([]float64, len(l.layers))
+	layerHeights := make([]float64, len(l.layers))
+	labelLines := make([]int, len(l.layers))
+
+	var maxWidth float64
+	for i, layer := range l.layers {
+		for j, n := range layer {
+			if j > 0 {
+				layerWidths[i] += svgNodeGap
+			}
+			layerWidths[i] += n.w
+			layerHeights[i] = math.Max(layerHeights[i], n.h)
+		}
+		maxWidth = math.Max(maxWidth, layerWidths[i])
+	}
+
+	for _, e := range l.edges {
+		if e.to.layer > e.from.layer && len(e.labels) > labelLines[e.from.layer] {
+			labelLines[e.from.layer] = len(e.labels)
+		}
+	}
+
+	y := float64(svgMargin + svgLineHeight + svgClusterPad)
+	for i, layer := range l.layers {
+		x := svgMargin + (maxWidth-layerWidths[i])/2
+		for _, n := range layer {
+			n.x = x
+			n.y = y + (layerHeights[i]-n.h)/2
+			x += n.w + svgNodeGap
+		}
+		y += layerHeights[i] + svgLayerGap + float64(labelLines[i]*svgLineHeight)
+	}
+
+	l.width = maxWidth + 2*svgMargin
+	l.height = y + svgMargin
+
+	for _, e := range l.edges {
+		if e.to.layer > e.from.layer {
+			continue
+		}
+		cx, _ := e.sideControl()
+		l.width = math.Max(l.width, cx+maxTextWidth(e.labels)+svgMargin)
+	}
+}
+
+// sideControl returns the control point used by edges that can't be drawn
+// downwards: self-loops, edges within a layer and edges going back up.
+func (e *svgEdge) sideControl() (float64, float64) {
+	right := math.Max(e.from.x+e.from.w, e.to.x+e.to.w)
+	if e.from == e.to {
+		return right + svgLoopSize, e.from.y + e.from.h/2
+	}
+
+	return right + svgNodeGap, (e.from.y + e.from.h/2 + e.to.y + e.to.h/2) / 2
+}
+
+func (l *svgLayout) write(w io.Writer) {
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>`+"\n")
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="sans-serif" font-size="%d">`+"\n",
+		l.width, l.height, l.width, l.height, svgFontSize)
+
+	colors := map[string]struct{}{}
+	var markers []string
+	for _, n := range l.nodes {
+		if _, exists := colors[n.color]; !exists {
+			colors[n.color] = struct{}{}
+			markers = append(markers, n.color)
+		}
+	}
+	fmt.Fprintf(w, "<defs>\n")
+	for _, c := range markers {
+		fmt.Fprintf(w, `<marker id="arrow-%s" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto"><path d="M0,0 L10,5 L0,10 z" fill="#%s"/></marker>`+"\n", c, c)
+	}
+	fmt.Fprintf(w, "</defs>\n")
+
+	l.writeClusters(w)
+	for _, e := range l.edges {
+		l.writeEdge(w, e)
+	}
+	for _, n := range l.nodes {
+		l.writeNode(w, n)
+	}
+
+	fmt.Fprintf(w, "</svg>\n")
+}
+
+func (l *svgLayout) writeClusters(w io.Writer) {
+	for i := range l.clusters {
+		c := &l.clusters[i]
+
+		x1, y1, x2, y2 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for _, n := range l.nodes {
+			if n.cluster != c {
+				continue
+			}
+			x1, y1 = math.Min(x1, n.x), math.Min(y1, n.y)
+			x2, y2 = math.Max(x2, n.x+n.w), math.Max(y2, n.y+n.h)
+		}
+		if math.IsInf(x1, 1) {
+			continue
+		}
+
+		x1, x2 = x1-svgClusterPad, x2+svgClusterPad
+		y1, y2 = y1-svgClusterPad-svgLineHeight, y2+svgClusterPad
+
+		fmt.Fprintf(w, `<g class="cluster"><rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="none" stroke="#888888" stroke-dasharray="4,2"/>`,
+			x1, y1, x2-x1, y2-y1)
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="middle" fill="#444444">%s</text></g>`+"\n",
+			(x1+x2)/2, y1+svgLineHeight-3, html.EscapeString(c.name))
+	}
+}
+
+func (l *svgLayout) writeEdge(w io.Writer, e *svgEdge) {
+	color := e.from.color
+
+	var path string
+	var lx, ly float64
+
+	switch {
+	case e.to.layer > e.from.layer:
+		x1, y1 := e.from.x+e.from.w/2, e.from.y+e.from.h
+		x2, y2 := e.to.x+e.to.w/2, e.to.y
+		path = fmt.Sprintf("M%.1f,%.1f L%.1f,%.1f", x1, y1, x2, y2)
+		lx, ly = (x1+x2)/2+4, (y1+y2)/2-float64(len(e.labels)*svgLineHeight)/2
+	case e.from == e.to:
+		cx, cy := e.sideControl()
+		x := e.from.x + e.from.w
+		path = fmt.Sprintf("M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f",
+			x, cy-e.from.h/4, cx, cy-e.from.h, cx, cy+e.from.h, x, cy+e.from.h/4)
+		lx, ly = cx+4, cy-float64(len(e.labels)*svgLineHeight)/2
+	default:
+		cx, cy := e.sideControl()
+		x1, y1 := e.from.x+e.from.w, e.from.y+e.from.h/2
+		x2, y2 := e.to.x+e.to.w, e.to.y+e.to.h/2
+		path = fmt.Sprintf("M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f", x1, y1, cx, y1, cx, y2, x2, y2)
+		lx, ly = 0.25*math.Max(x1, x2)+0.75*cx+4, cy-float64(len(e.labels)*svgLineHeight)/2
+	}
+
+	fmt.Fprintf(w, `<g class="edge"><path d="%s" fill="none" stroke="#%s" marker-end="url(#arrow-%s)"/>`, path, color, color)
+	fmt.Fprintf(w, `<text x="%.1f" y="%.1f" fill="#%s">`, lx, ly, color)
+	dy := svgFontSize
+	for _, label := range e.labels {
+		fmt.Fprintf(w, `<tspan x="%.1f" dy="%d">%s</tspan>`, lx, dy, html.EscapeString(label))
+		dy = svgLineHeight
+	}
+	fmt.Fprintf(w, "</text></g>\n")
+}
+
+func (l *svgLayout) writeNode(w io.Writer, n *svgNode) {
+	fmt.Fprintf(w, `<g class="node" id="state-%s">`, n.title)
+	fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="white" stroke="#%s"/>`, n.x, n.y, n.w, n.h, n.color)
+	fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#%s"/>`, n.x+n.titleW, n.y, n.x+n.titleW, n.y+n.h, n.color)
+	fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="middle" font-weight="bold" fill="#%s">%s</text>`,
+		n.x+n.titleW/2, n.y+n.h/2+svgFontSize/2-2, n.color, html.EscapeString(n.title))
+	for i, line := range n.lines {
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" fill="#%s">%s</text>`,
+			n.x+n.titleW+svgCellPadding, n.y+svgCellPadding+float64(i*svgLineHeight)+svgFontSize, n.color, html.EscapeString(line))
+	}
+	fmt.Fprintf(w, "</g>\n")
+}
+
+func svgTextWidth(s string) float64 {
+	return float64(len([]rune(s)) * svgCharWidth)
+}
+
+func maxTextWidth(lines []string) float64 {
+	var res float64
+	for _, line := range lines {
+		res = math.Max(res, svgTextWidth(line))
+	}
+
+	return res
+}
+
+func hsvToRGB(h, s, v float64) string {
+	i := math.Floor(h * 6)
+	f := h*6 - i
+	p, q, t := v*(1-s), v*(1-f*s), v*(1-(1-f)*s)
+
+	var r, g, b float64
+	switch int(i) % 6 {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+
+	return fmt.Sprintf("%02x%02x%02x", uint8(math.Round(r*255)), uint8(math.Round(g*255)), uint8(math.Round(b*255)))
+}